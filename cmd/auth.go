@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// tokenCache keeps OAuth tokens on disk, keyed by audience (the API
+// base url) and client, so that repeated CLI invocations can reuse
+// them.
+type tokenCache struct {
+	path string
+}
+
+// tokenCacheKey is the key of the tokens of a client for an audience.
+// The client id is hashed, so that it is not written to disk. Tokens
+// of one account are never used for another, even for the same api.
+func tokenCacheKey(audience, clientID string) string {
+	return audience + "#" + shortHash([]byte(clientID))
+}
+
+// splitTokenCacheKey returns the audience and client hash of a key.
+func splitTokenCacheKey(key string) (string, string) {
+	i := strings.LastIndex(key, "#")
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i], key[i+1:]
+}
+
+func defaultTokenCache() (*tokenCache, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenCache{filepath.Join(dir, "reloadly", "tokens.json")}, nil
+}
+
+func (c *tokenCache) load() (map[string]*reloadly.Token, error) {
+	tokens := map[string]*reloadly.Token{}
+
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &tokens)
+	return tokens, err
+}
+
+func (c *tokenCache) get(key string) *reloadly.Token {
+	tokens, err := c.load()
+	if err != nil {
+		return nil
+	}
+	return tokens[key]
+}
+
+func (c *tokenCache) put(key string, token *reloadly.Token) error {
+	tokens, err := c.load()
+	if err != nil {
+		tokens = map[string]*reloadly.Token{}
+	}
+	tokens[key] = token
+
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that
+	// concurrent invocations never read a partial file.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+func (c *tokenCache) clear() error {
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// source wraps upstream so that the first token comes from the cache,
+// if it holds a fresh one under key (see tokenCacheKey). Any later call means the
+// Service needs a new token, so it goes to upstream, and the result is
+// written back to the cache.
+func (c *tokenCache) source(key string, upstream reloadly.TokenSource) reloadly.TokenSource {
+	var mu sync.Mutex
+	first := true
+
+	return reloadly.TokenSourceFunc(func(ctx context.Context) (*reloadly.Token, error) {
+		mu.Lock()
+		useCache := first
+		first = false
+		mu.Unlock()
+
+		if useCache {
+			token := c.get(key)
+			if !token.NeedsRefresh(reloadly.DefaultTokenRefreshSkew) {
+				return token, nil
+			}
+		}
+
+		token, err := upstream.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// A cache we cannot write to should not stop the command.
+		_ = c.put(key, token)
+		return token, nil
+	})
+}
+
+func loadServiceForApi(cmd *cobra.Command) (*reloadly.Service, error) {
+	api, err := cmd.Flags().GetString("api")
+	if err != nil {
+		return nil, err
+	}
+
+	var svc *reloadly.Service
+	switch api {
+	case "topups":
+		svc = reloadly.NewTopups()
+	case "gift-cards":
+		svc = reloadly.NewGiftCards()
+	default:
+		return nil, fmt.Errorf("unknown api %v, expected topups or gift-cards", api)
+	}
+
+	sandbox, err := cmd.Flags().GetBool("sandbox")
+	if err != nil {
+		return nil, err
+	}
+
+	if sandbox {
+		svc.Sandbox()
+	}
+	return svc, nil
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage the cached Reloadly access tokens",
+	Long:  "Manage the access tokens the CLI caches between invocations",
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Request a new access token and cache it",
+	Long:  "Request a new access token with the configured credentials and cache it for later invocations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		svc, err := loadServiceForApi(cmd)
+		if err != nil {
+			return err
+		}
+
+		cache, err := defaultTokenCache()
+		if err != nil {
+			return err
+		}
+
+		token, err := tokenSource(svc).Token(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		err = cache.put(tokenCacheKey(svc.BaseUrl, viper.GetString("reloadly_id")), token)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Authorized with Reloadly (%v), token expires at %v\n", svc.BaseUrl, token.Expiry().Format(time.RFC3339))
+		return nil
+	},
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the cached access tokens",
+	Long:  "Show the cached access tokens and when they expire",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache, err := defaultTokenCache()
+		if err != nil {
+			return err
+		}
+
+		tokens, err := cache.load()
+		if err != nil {
+			return err
+		}
+
+		if len(tokens) == 0 {
+			fmt.Println("No cached tokens")
+			return nil
+		}
+
+		keys := []string{}
+		for key := range tokens {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Printf("%-45s %-14s %-26s %-8s\n", "Audience", "Client", "Expires", "Status")
+		for _, key := range keys {
+			token := tokens[key]
+			status := "valid"
+			if token.ExpiresWithin(0) {
+				status = "expired"
+			}
+			audience, client := splitTokenCacheKey(key)
+			fmt.Printf("%-45s %-14s %-26s %-8s\n", audience, client, token.Expiry().Format(time.RFC3339), status)
+		}
+		return nil
+	},
+}
+
+var authLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the cached access tokens",
+	Long:  "Remove all cached access tokens",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache, err := defaultTokenCache()
+		if err != nil {
+			return err
+		}
+
+		err = cache.clear()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Removed cached tokens")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authStatusCmd)
+	authCmd.AddCommand(authLogoutCmd)
+
+	authLoginCmd.Flags().String("api", "topups", "which api to log in to: topups or gift-cards")
+}
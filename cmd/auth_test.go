@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func TestTokenCachePutWritesPrivateFile(t *testing.T) {
+	cache := &tokenCache{filepath.Join(t.TempDir(), "reloadly", "tokens.json")}
+
+	token := &reloadly.Token{AccessToken: "foo", ExpiresIn: 3600, IssuedAt: time.Now()}
+	err := cache.put("https://topups.reloadly.com", token)
+	assert.Nil(t, err)
+
+	info, err := os.Stat(cache.path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.Equal(t, "foo", cache.get("https://topups.reloadly.com").AccessToken)
+	assert.Nil(t, cache.get("https://giftcards.reloadly.com"))
+
+	err = cache.clear()
+	assert.Nil(t, err)
+	assert.Nil(t, cache.get("https://topups.reloadly.com"))
+}
+
+func TestTokenCacheSourceReusesFreshCachedToken(t *testing.T) {
+	cache := &tokenCache{filepath.Join(t.TempDir(), "tokens.json")}
+	cache.put("aud", &reloadly.Token{AccessToken: "cached", ExpiresIn: 3600, IssuedAt: time.Now()})
+
+	calls := 0
+	upstream := reloadly.TokenSourceFunc(func(ctx context.Context) (*reloadly.Token, error) {
+		calls++
+		return &reloadly.Token{AccessToken: "new", ExpiresIn: 3600, IssuedAt: time.Now()}, nil
+	})
+
+	source := cache.source("aud", upstream)
+
+	token, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", token.AccessToken)
+	assert.Equal(t, 0, calls)
+
+	// a second call means the service needs a new token
+	token, err = source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "new", token.AccessToken)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "new", cache.get("aud").AccessToken)
+}
+
+func TestTokenCacheSourceSkipsExpiredCachedToken(t *testing.T) {
+	cache := &tokenCache{filepath.Join(t.TempDir(), "tokens.json")}
+	cache.put("aud", &reloadly.Token{AccessToken: "cached", ExpiresIn: 60, IssuedAt: time.Now().Add(-time.Hour)})
+
+	upstream := reloadly.StaticToken(&reloadly.Token{AccessToken: "new", ExpiresIn: 3600, IssuedAt: time.Now()})
+
+	token, err := cache.source("aud", upstream).Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "new", token.AccessToken)
+}
+
+func TestTokenCacheSourceIsPerClient(t *testing.T) {
+	cache := &tokenCache{filepath.Join(t.TempDir(), "tokens.json")}
+	aud := "https://topups.reloadly.com"
+
+	first := reloadly.StaticToken(&reloadly.Token{AccessToken: "first", ExpiresIn: 3600, IssuedAt: time.Now()})
+	token, err := cache.source(tokenCacheKey(aud, "id-1"), first).Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "first", token.AccessToken)
+
+	// switching credentials must not reuse the other account's token
+	second := reloadly.StaticToken(&reloadly.Token{AccessToken: "second", ExpiresIn: 3600, IssuedAt: time.Now()})
+	token, err = cache.source(tokenCacheKey(aud, "id-2"), second).Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "second", token.AccessToken)
+
+	token, err = cache.source(tokenCacheKey(aud, "id-1"), second).Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "first", token.AccessToken)
+
+	audience, client := splitTokenCacheKey(tokenCacheKey(aud, "id-1"))
+	assert.Equal(t, aud, audience)
+	assert.Equal(t, 12, len(client))
+	assert.NotContains(t, client, "id-1")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func printBalance(w io.Writer, b *reloadly.Balance, output string) error {
+	switch output {
+	case "json":
+		out, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+		return nil
+	case "table":
+		updated := ""
+		if b.UpdatedAt != nil {
+			updated = b.UpdatedAt.Time().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%-15s %-10s %-26s\n", "Balance", "Currency", "Updated")
+		fmt.Fprintf(w, "%-15.2f %-10s %-26s\n", b.Balance, b.CurrencyCode, updated)
+		return nil
+	default:
+		return fmt.Errorf("unknown output %v, expected json or table", output)
+	}
+}
+
+var topupsBalanceCmd = &cobra.Command{
+	Use:   "balance",
+	Short: "Show the balance of the topups account",
+	Long:  "Show the balance of the topups account",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		svc, err := LoadTopupsService(cmd)
+		if err != nil {
+			return err
+		}
+
+		b, err := svc.Topups().BalanceContext(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printBalance(os.Stdout, b, output)
+	},
+}
+
+var giftCardsBalanceCmd = &cobra.Command{
+	Use:   "balance",
+	Short: "Show the balance of the gift cards account",
+	Long:  "Show the balance of the gift cards account",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		svc, err := LoadGiftCardsService(cmd)
+		if err != nil {
+			return err
+		}
+
+		b, err := svc.GiftCards().BalanceContext(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return printBalance(os.Stdout, b, output)
+	},
+}
+
+func init() {
+	topupsCmd.AddCommand(topupsBalanceCmd)
+	giftCardsCmd.AddCommand(giftCardsBalanceCmd)
+
+	topupsBalanceCmd.Flags().StringP("output", "o", "table", "output format: json or table")
+	giftCardsBalanceCmd.Flags().StringP("output", "o", "table", "output format: json or table")
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func TestPrintBalanceTable(t *testing.T) {
+	var b reloadly.Balance
+	err := json.Unmarshal([]byte(`{"balance": 550.75, "currencyCode": "USD", "updatedAt": "2018-12-04 08:45:51"}`), &b)
+	assert.Nil(t, err)
+
+	buf := new(bytes.Buffer)
+	err = printBalance(buf, &b, "table")
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "550.75          USD        2018-12-04T08:45:51Z")
+
+	buf.Reset()
+	err = printBalance(buf, &b, "json")
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), `"updatedAt": "2018-12-04T08:45:51Z"`)
+
+	err = printBalance(buf, &b, "yaml")
+	assert.NotNil(t, err)
+}
@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/jszwec/csvutil"
 	"github.com/nandanrao/chance"
 	"github.com/spf13/cobra"
@@ -15,26 +17,17 @@ import (
 func LoadBatchCsv(path string) ([]reloadly.TopupJob, error) {
 	var jobs []reloadly.TopupJob
 
-	b, err := ioutil.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	err = csvutil.Unmarshal(b, &jobs)
-
-	if len(jobs) == 0 {
-		return jobs, fmt.Errorf("We could not parse the data from the csv. Please ensure it is in the right format and that the required fields (number, amount, country) are present for each row in the csv file.")
-	}
-
-	validate := validator.New()
-	for _, job := range jobs {
-		err = validate.Struct(job)
-		if err != nil {
-			return jobs, err
-		}
-	}
-
-	return jobs, nil
+	err = readBatchCsv(bufio.NewReader(f), func(i int, job reloadly.TopupJob) error {
+		jobs = append(jobs, job)
+		return nil
+	})
+	return jobs, err
 }
 
 func WriteBatchCsv(path string, responses []*reloadly.TopupWorkerResponse) error {
@@ -47,32 +40,180 @@ func WriteBatchCsv(path string, responses []*reloadly.TopupWorkerResponse) error
 	return err
 }
 
-func BatchTopup(svc *reloadly.Service, numWorkers int, jobs []reloadly.TopupJob) []*reloadly.TopupWorkerResponse {
-	tt := make([]interface{}, len(jobs))
-	for i := range jobs {
-		tt[i] = &jobs[i]
+// skippedResponse is the response of a job that was never sent.
+func skippedResponse(err error, d *reloadly.TopupJob) *reloadly.TopupWorkerResponse {
+	tr := &reloadly.TopupResponse{
+		OperatorName:     d.Operator,
+		RecipientPhone:   d.Number,
+		CountryCode:      d.Country,
+		RequestedAmount:  d.Amount,
+		CustomIdentifier: d.CustomIdentifier,
 	}
+	r := &reloadly.TopupWorkerResponse{JobID: d.ID, TopupResponse: tr}
+	return r.SetError(err)
+}
 
-	tasks := chance.Flatten(tt)
+// BatchTopup does every job with numWorkers workers and returns
+// their responses. See streamBatchTopup.
+func BatchTopup(ctx context.Context, svc *reloadly.Service, numWorkers int, jobs []reloadly.TopupJob, costs []float64, b *budget, j *journal) []*reloadly.TopupWorkerResponse {
+	res := []*reloadly.TopupWorkerResponse{}
+	for r := range streamBatchTopup(ctx, svc, numWorkers, jobTasks(jobs), costs, b, j) {
+		res = append(res, r.(*reloadly.TopupWorkerResponse))
+	}
+	return res
+}
+
+// streamBatchTopup does the batchTasks it receives with numWorkers
+// workers, and sends their responses as soon as they are done. Tasks
+// done in a previous run are passed through without sending them.
+//
+// If b is not nil, jobs are only sent while their projected cost
+// (from costs, which may be nil) fits in the budget, and skipped
+// otherwise. If j is not nil, every response is written to it as soon
+// as it is done.
+func streamBatchTopup(ctx context.Context, svc *reloadly.Service, numWorkers int, tasks <-chan interface{}, costs []float64, b *budget, j *journal) <-chan interface{} {
 	worker := reloadly.TopupWorker(*svc)
-	outputs := chance.Pool(numWorkers, tasks, worker.Work)
+	do := func(t batchTask) *reloadly.TopupWorkerResponse {
+		if b == nil {
+			return worker.DoContext(ctx, t.job)
+		}
 
-	res := []*reloadly.TopupWorkerResponse{}
-	for r := range outputs {
-		rr := r.(*reloadly.TopupWorkerResponse)
-		res = append(res, rr)
+		cost := 0.0
+		if t.i < len(costs) {
+			cost = costs[t.i]
+		}
+
+		if !b.reserve(cost) {
+			err := reloadly.ReloadlyError{ErrorCode: "BUDGET_EXCEEDED", Message: fmt.Sprintf("Not sent, as it would exceed the max spend of %v", b.max)}
+			return skippedResponse(err, t.job)
+		}
+
+		res := worker.DoContext(ctx, t.job)
+
+		// Anything but a clear rejection may have been charged,
+		// including failures we cannot classify, which count at
+		// their projected cost.
+		spent := cost
+		if succeeded(res) {
+			spent = res.RequestedAmount
+		} else if res.ErrorCode != "" && !reloadly.IsChargeUncertain(res.Err) {
+			spent = 0
+		}
+		b.settle(cost, spent)
+		return res
 	}
 
-	return res
+	work := func(i interface{}) interface{} {
+		t := i.(batchTask)
+		if t.done != nil {
+			t.done.Row = t.i + 1
+			t.done.JobID = t.job.ID
+			return t.done
+		}
+
+		res := do(t)
+		res.Row = t.i + 1
+
+		err := j.record(t.job, res)
+		if err != nil {
+			logger.Error("could not write to journal", "custom_identifier", t.job.CustomIdentifier, "error", err)
+		}
+		return res
+	}
+
+	return chance.Pool(numWorkers, tasks, work)
+}
+
+// writeResponses writes each response (or quote) as soon as it is
+// done. If one cannot be written, it cancels the batch, and only
+// drains the rest. errs is the error channel of the batch input,
+// which is checked once every response is done. If fn is not nil, it
+// is called with every response written.
+func writeResponses(out responseWriter, responses <-chan interface{}, errs <-chan error, cancel context.CancelFunc, fn func(interface{})) (int, error) {
+	written := 0
+	var writeErr error
+	for r := range responses {
+		if writeErr != nil {
+			continue
+		}
+
+		writeErr = out.Write(r)
+		if writeErr != nil {
+			logger.Error("could not write response, stopping batch", "error", writeErr)
+			cancel()
+			continue
+		}
+		written++
+
+		if fn != nil {
+			fn(r)
+		}
+	}
+
+	readErr := <-errs
+	err := out.Close()
+	if writeErr != nil {
+		return written, writeErr
+	}
+	if readErr != nil {
+		return written, readErr
+	}
+	return written, err
+}
+
+// streamBatchQuotes quotes the batchTasks it receives with numWorkers
+// workers, and sends the quotes as soon as they are done.
+func streamBatchQuotes(ctx context.Context, svc *reloadly.Service, numWorkers int, tasks <-chan interface{}) <-chan interface{} {
+	worker := reloadly.TopupWorker(*svc)
+	work := func(i interface{}) interface{} {
+		t := i.(batchTask)
+		q := worker.QuoteContext(ctx, t.job)
+		q.Row = t.i + 1
+		return q
+	}
+
+	return chance.Pool(numWorkers, tasks, work)
+}
+
+// quoteBatch writes the quote of every row of the input to output,
+// without sending anything.
+func quoteBatch(ctx context.Context, cancel context.CancelFunc, svc *reloadly.Service, in *batchInput, output, format string, numWorkers int, ordered bool) error {
+	out, err := createResponseWriter(output, format, reloadly.TopupWorkerQuote{})
+	if err != nil {
+		return err
+	}
+
+	tasks, errs := in.tasks(ctx)
+	quotes := streamBatchQuotes(ctx, svc, numWorkers, tasks)
+	if ordered {
+		quotes = inOrder(quotes)
+	}
+
+	cost := 0.0
+	failed := 0
+	written, err := writeResponses(out, quotes, errs, cancel, func(r interface{}) {
+		q := r.(*reloadly.TopupWorkerQuote)
+		if q.ErrorCode != "" || q.Err != nil {
+			failed++
+			return
+		}
+		cost += q.Cost
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("wrote batch quotes", "quotes", written, "failed", failed, "cost", cost, "output", output)
+	return nil
 }
 
 var batchCmd = &cobra.Command{
 	Use:   "batch",
-	Short: "Make airtime recharges to multiple mobile numbers using a CSV file",
-	Long:  "Make airtime recharges to multiple mobile numbers using a CSV file",
+	Short: "Make airtime recharges to multiple mobile numbers using a CSV or JSON Lines file",
+	Long:  "Make airtime recharges to multiple mobile numbers using a CSV or JSON Lines file. Use - as the input to read from stdin, or as the output to write to stdout.",
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 2 {
-			return errors.New("requires 2 positional args [input csv] and [output csv]")
+			return errors.New("requires 2 positional args [input] and [output]")
 		}
 		return nil
 	},
@@ -85,23 +226,241 @@ var batchCmd = &cobra.Command{
 			return err
 		}
 
-		details, err := LoadBatchCsv(input)
+		formatFlag, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+
+		inputFormat, err := batchFormat(input, formatFlag, "csv")
+		if err != nil {
+			return err
+		}
+
+		outputFormatFlag, err := cmd.Flags().GetString("output-format")
+		if err != nil {
+			return err
+		}
+
+		outputFormat, err := batchFormat(output, outputFormatFlag, inputFormat)
+		if err != nil {
+			return err
+		}
+
+		// The input is read more than once, so stdin is copied to a
+		// file first.
+		inputPath, removeInput, err := spoolStdin(input)
+		if err != nil {
+			return err
+		}
+		defer removeInput()
+
+		runID, err := cmd.Flags().GetString("run-id")
+		if err != nil {
+			return err
+		}
+
+		if runID == "" {
+			runID, err = fileRunID(inputPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		idTemplate, err := cmd.Flags().GetString("id-template")
+		if err != nil {
+			return err
+		}
+
+		journalPath, err := cmd.Flags().GetString("journal")
 		if err != nil {
 			return err
 		}
 
+		if journalPath == "" && output == "-" {
+			journalPath = "batch-" + runID + ".journal.jsonl"
+		} else if journalPath == "" {
+			journalPath = output + ".journal.jsonl"
+		}
+
+		resume, err := cmd.Flags().GetBool("resume")
+		if err != nil {
+			return err
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		// Quotes are not journaled, and a dry run quotes every row.
+		completed := map[string]*reloadly.TopupWorkerResponse{}
+		if !dryRun {
+			completed, err = readJournal(journalPath, runID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !resume && len(completed) > 0 {
+			return fmt.Errorf("journal %v already has %v completed rows of this input, use --resume to skip them or remove it to send them again", journalPath, len(completed))
+		}
+
+		in := &batchInput{path: inputPath, format: inputFormat, runID: runID, idTemplate: idTemplate, completed: completed, detected: newDetections()}
+
 		numWorkers, err := cmd.Flags().GetInt("workers")
 		if err != nil {
 			return err
 		}
 
-		responses := BatchTopup(svc, numWorkers, details)
-		err = WriteBatchCsv(output, responses)
+		if numWorkers < 1 {
+			return fmt.Errorf("--workers must be at least 1, got %v", numWorkers)
+		}
+
+		rps, err := cmd.Flags().GetFloat64("rps")
+		if err != nil {
+			return err
+		}
+
+		burst, err := cmd.Flags().GetInt("burst")
+		if err != nil {
+			return err
+		}
+
+		if rps < 0 {
+			return fmt.Errorf("--rps must not be negative, got %v", rps)
+		}
+
+		if burst < 1 {
+			return fmt.Errorf("--burst must be at least 1, got %v", burst)
+		}
+
+		if rps > 0 {
+			svc.SetRateLimit(rps, burst)
+		}
+
+		maxSpend, err := cmd.Flags().GetFloat64("max-spend")
+		if err != nil {
+			return err
+		}
+
+		skipPreflight, err := cmd.Flags().GetBool("skip-preflight")
+		if err != nil {
+			return err
+		}
+
+		onInsufficient, err := cmd.Flags().GetString("on-insufficient-balance")
+		if err != nil {
+			return err
+		}
+
+		idempotent, err := cmd.Flags().GetBool("idempotent")
+		if err != nil {
+			return err
+		}
+
+		ordered, err := cmd.Flags().GetBool("ordered")
+		if err != nil {
+			return err
+		}
+
+		// Rows in flight when a batch is killed are not in the
+		// journal, so check the report before sending them again.
+		svc.IdempotentTopups = idempotent || resume
+
+		if onInsufficient != "refuse" && onInsufficient != "warn" {
+			return fmt.Errorf("unknown --on-insufficient-balance %v, expected refuse or warn", onInsufficient)
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+
+		// Check every row before anything is sent.
+		report, err := validateBatchFile(ctx, inputPath, inputFormat, newOperatorCache(svc.Topups()))
+		if err != nil {
+			return err
+		}
+
+		if len(report.Issues) > 0 {
+			err = report.print(cmd.ErrOrStderr(), "table")
+			if err != nil {
+				return err
+			}
+		}
+
+		if n := report.errors(); n > 0 {
+			return fmt.Errorf("%v has %v errors, not sending any topups", input, n)
+		}
+
+		rows, err := in.count(ctx)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return quoteBatch(ctx, cancel, svc, in, output, outputFormat, numWorkers, ordered)
+		}
+
+		if resume {
+			logger.Info("resuming batch", "journal", journalPath, "completed", len(completed), "spent", completedSpend(completed), "rows", rows)
+		}
+
+		var costs []float64
+		if !skipPreflight {
+			tasks, errs := in.tasks(ctx)
+			p := projectSpend(ctx, svc, numWorkers, tasks, in.detected)
+			if err := <-errs; err != nil {
+				return err
+			}
+			costs = p.costs
+
+			err = checkBalance(ctx, svc, p, onInsufficient == "warn")
+			if err != nil {
+				return err
+			}
+
+			if maxSpend > 0 && p.total > maxSpend {
+				logger.Warn("projected spend exceeds --max-spend, some rows will not be sent", "spend", p.total, "max_spend", maxSpend)
+			}
+		}
+
+		var b *budget
+		if maxSpend > 0 {
+			// The cap is for the whole batch, so what previous
+			// runs spent counts against it.
+			b = newBudget(maxSpend)
+			b.settle(0, completedSpend(completed))
+		}
+
+		j, err := openJournal(journalPath, runID)
+		if err != nil {
+			return err
+		}
+		defer j.Close()
+
+		out, err := createResponseWriter(output, outputFormat, reloadly.TopupWorkerResponse{})
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(fmt.Sprintf("Successfully wrote %v responses from %v rows", len(responses), len(details)))
+		// If the output cannot be written, the journal has the rest.
+		// This is the last read of the input, so rows are dropped
+		// from memory as they are sent.
+		in.drop = true
+		tasks, errs := in.tasks(ctx)
+		responses := streamBatchTopup(ctx, svc, numWorkers, tasks, costs, b, j)
+		if ordered {
+			responses = inOrder(responses)
+		}
+
+		written, err := writeResponses(out, responses, errs, cancel, nil)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("wrote batch responses", "responses", written, "rows", rows, "output", output, "run_id", runID)
+		if b != nil {
+			logger.Info("batch spend", "spent", b.total(), "max_spend", maxSpend)
+		}
 
 		return nil
 	},
@@ -111,4 +470,18 @@ func init() {
 	topupsCmd.AddCommand(batchCmd)
 
 	batchCmd.Flags().IntP("workers", "w", 12, "Parallelism for http requests")
+	batchCmd.Flags().String("format", "", "Input format: csv or jsonl (defaults to the file extension, or csv for stdin)")
+	batchCmd.Flags().String("output-format", "", "Output format: csv or jsonl (defaults to the file extension, or the input format for stdout)")
+	batchCmd.Flags().Float64("rps", 0, "Max requests per second to Reloadly (0 for no limit)")
+	batchCmd.Flags().Int("burst", 1, "Max burst of requests above --rps")
+	batchCmd.Flags().Float64("max-spend", 0, "Stop sending topups once this much (in the account currency) has been spent (0 for no limit)")
+	batchCmd.Flags().Bool("skip-preflight", false, "Do not project the spend of the batch and check it against the balance before starting")
+	batchCmd.Flags().String("on-insufficient-balance", "refuse", "What to do if the projected spend exceeds the balance: refuse or warn")
+	batchCmd.Flags().String("run-id", "", "Run id used in generated custom identifiers (defaults to a hash of the input file)")
+	batchCmd.Flags().String("id-template", DefaultIDTemplate, "Go template of the custom identifier of rows without one, with .RunID, .ID, .Row, .Hash, .Number, .Amount, .Country and .Operator")
+	batchCmd.Flags().String("journal", "", "Where to write the response of each row as soon as it is done (defaults to [output].journal.jsonl, or batch-[run id].journal.jsonl for stdout)")
+	batchCmd.Flags().Bool("resume", false, "Skip rows that completed successfully in a previous run of the same input, according to the journal")
+	batchCmd.Flags().Bool("dry-run", false, "Write the quote of each row (operator, amount, cost and delivered amount) instead of sending it")
+	batchCmd.Flags().Bool("ordered", false, "Write responses in the order of the input rows, rather than as they are done")
+	batchCmd.Flags().Bool("idempotent", false, "Send rows with a custom_identifier at most once, checking the transactions report before sending them")
 }
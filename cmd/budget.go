@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nandanrao/chance"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// operatorCache looks up operators by country and name, fetching
+// the operators of each country only once. Countries are fetched
+// concurrently, each under its own lock.
+type operatorCache struct {
+	mu        sync.Mutex
+	svc       *reloadly.TopupsService
+	countries map[string]*countryOperators
+}
+
+type countryOperators struct {
+	mu      sync.Mutex
+	fetched bool
+	ops     []reloadly.Operator
+}
+
+func newOperatorCache(svc *reloadly.TopupsService) *operatorCache {
+	return &operatorCache{svc: svc, countries: map[string]*countryOperators{}}
+}
+
+// operators returns the operators of the country, fetching them if
+// they are not cached yet. Failed fetches are not cached.
+func (c *operatorCache) operators(ctx context.Context, country string) ([]reloadly.Operator, error) {
+	c.mu.Lock()
+	co, ok := c.countries[country]
+	if !ok {
+		co = &countryOperators{}
+		c.countries[country] = co
+	}
+	c.mu.Unlock()
+
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if !co.fetched {
+		ops, err := c.svc.OperatorsByCountryContext(ctx, country)
+		if err != nil {
+			return nil, err
+		}
+		co.ops = ops
+		co.fetched = true
+	}
+	return co.ops, nil
+}
+
+func (c *operatorCache) find(ctx context.Context, country, name string) (*reloadly.Operator, error) {
+	ops, err := c.operators(ctx, country)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ops {
+		if ops[i].Name == name {
+			return &ops[i], nil
+		}
+	}
+	return nil, reloadly.ReloadlyError{
+		ErrorCode: "OPERATOR_NOT_FOUND",
+		Message:   fmt.Sprintf("Could not find operator with name: %v in country: %v", name, country),
+	}
+}
+
+// detections remembers the operators auto-detected for numbers while
+// projecting the spend of a batch, so that the batch does not detect
+// them again when sending. Operators are kept once per id, but every
+// number costs an entry until it is dropped. A nil detections
+// remembers nothing.
+type detections struct {
+	mu        sync.Mutex
+	operators map[int64]*reloadly.Operator
+	numbers   map[string]int64
+}
+
+func newDetections() *detections {
+	return &detections{operators: map[int64]*reloadly.Operator{}, numbers: map[string]int64{}}
+}
+
+// get returns the operator detected for a number, forgetting the
+// number if drop is set.
+func (d *detections) get(number, country string, drop bool) *reloadly.Operator {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := country + "|" + number
+	id, ok := d.numbers[key]
+	if !ok {
+		return nil
+	}
+	if drop {
+		delete(d.numbers, key)
+	}
+	return d.operators[id]
+}
+
+func (d *detections) put(number, country string, op *reloadly.Operator) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.operators[op.OperatorID]; !ok {
+		d.operators[op.OperatorID] = op
+	}
+	d.numbers[country+"|"+number] = op.OperatorID
+}
+
+// projection is the projected cost of every job of a batch, in the
+// currency of the account.
+type projection struct {
+	costs []float64
+	total float64
+
+	// failed counts the jobs whose cost could not be projected,
+	// which are left at 0.
+	failed int
+}
+
+// projectSpend works out what each job will cost, the same way the
+// TopupWorker picks the amount to send: with the job's operator, or
+// the auto-detected one, and GetSuggestedAmount. Jobs done in a
+// previous run cost nothing. Auto-detected operators are remembered
+// in det, if not nil.
+func projectSpend(ctx context.Context, svc *reloadly.Service, numWorkers int, tasks <-chan interface{}, det *detections) *projection {
+	cache := newOperatorCache(svc.Topups())
+
+	type result struct {
+		i    int
+		id   string
+		cost float64
+		err  error
+	}
+
+	work := func(i interface{}) interface{} {
+		t := i.(batchTask)
+		if t.done != nil {
+			return result{t.i, t.job.ID, 0, nil}
+		}
+
+		var op *reloadly.Operator
+		var err error
+		if t.job.Operator != "" {
+			op, err = cache.find(ctx, t.job.Country, t.job.Operator)
+		} else if op = det.get(t.job.Number, t.job.Country, false); op == nil {
+			op, err = svc.Topups().OperatorsAutoDetectContext(ctx, t.job.Number, t.job.Country)
+			if err == nil {
+				det.put(t.job.Number, t.job.Country, op)
+			}
+		}
+		if err != nil {
+			return result{t.i, t.job.ID, 0, err}
+		}
+
+		cost, err := reloadly.GetSuggestedAmount(op, t.job.Amount, t.job.Tolerance)
+		return result{t.i, t.job.ID, cost, err}
+	}
+
+	p := &projection{costs: []float64{}}
+	for r := range chance.Pool(numWorkers, tasks, work) {
+		res := r.(result)
+		for len(p.costs) <= res.i {
+			p.costs = append(p.costs, 0)
+		}
+
+		if res.err != nil {
+			p.failed++
+			logger.Warn("could not project cost of batch row", "row", res.i+1, "id", res.id, "error", res.err)
+			continue
+		}
+		p.costs[res.i] = res.cost
+		p.total += res.cost
+	}
+	return p
+}
+
+// budget caps the spend of a batch. Each job reserves its projected
+// cost before it is sent, so that concurrent jobs cannot overshoot,
+// and settles it with what was actually charged once it is done.
+type budget struct {
+	mu       sync.Mutex
+	max      float64
+	spent    float64
+	reserved float64
+}
+
+func newBudget(max float64) *budget {
+	return &budget{max: max}
+}
+
+// reserve reports whether amount fits in what is left of the budget,
+// and if so sets it aside. Once the budget is spent nothing fits, not
+// even jobs whose cost is unknown (0).
+func (b *budget) reserve(amount float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spent+b.reserved >= b.max || b.spent+b.reserved+amount > b.max {
+		return false
+	}
+	b.reserved += amount
+	return true
+}
+
+func (b *budget) settle(reserved, spent float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reserved -= reserved
+	b.spent += spent
+}
+
+func (b *budget) total() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spent
+}
+
+// checkBalance compares the projected spend of a batch with the
+// account balance, and fails if it is not enough, unless warnOnly.
+func checkBalance(ctx context.Context, svc *reloadly.Service, p *projection, warnOnly bool) error {
+	balance, err := svc.Topups().BalanceContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("projected batch spend", "spend", p.total, "balance", balance.Balance, "currency", balance.CurrencyCode, "unprojected_rows", p.failed)
+
+	if p.total <= balance.Balance {
+		return nil
+	}
+
+	msg := fmt.Sprintf("projected spend of %.2f %v exceeds the balance of %.2f %v", p.total, balance.CurrencyCode, balance.Balance, balance.CurrencyCode)
+	if warnOnly {
+		logger.Warn(msg)
+		return nil
+	}
+	return reloadly.ReloadlyError{ErrorCode: "INSUFFICIENT_BALANCE", Message: msg}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func TestBudgetReservesUntilMax(t *testing.T) {
+	b := newBudget(10)
+
+	assert.True(t, b.reserve(4))
+	assert.True(t, b.reserve(4))
+	assert.False(t, b.reserve(4))
+
+	b.settle(4, 0)
+	assert.True(t, b.reserve(4))
+
+	b.settle(4, 4)
+	b.settle(4, 4)
+	assert.Equal(t, float64(8), b.total())
+	assert.False(t, b.reserve(4))
+	assert.True(t, b.reserve(2))
+
+	b.settle(2, 2)
+	assert.False(t, b.reserve(0))
+}
+
+func testTopupsServer(t *testing.T) *reloadly.Service {
+	dat, _ := ioutil.ReadFile("../reloadly/test/airtel.json")
+	airtel := string(dat)
+	ts, mux := reloadly.TestServerMux()
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	mux.HandleFunc("/operators/auto-detect/phone/+456/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		fmt.Fprint(w, `{"errorCode": "COULD_NOT_AUTO_DETECT_OPERATOR", "message": "nope"}`)
+	})
+
+	mux.HandleFunc("/operators/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "[%v]", airtel)
+	})
+
+	mux.HandleFunc("/accounts/balance", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"balance": 5, "currencyCode": "USD"}`)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"transactionId": 1, "requestedAmount": 1.82, "requestedAmountCurrencyCode": "USD"}`)
+	})
+
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+	return svc
+}
+
+func TestProjectSpendUsesSuggestedAmounts(t *testing.T) {
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN"},
+		{Number: "+123", Amount: 100, Country: "IN", Operator: "Airtel India"},
+		{Number: "+456", Amount: 100, Country: "IN"},
+	}
+
+	p := projectSpend(context.Background(), svc, 2, jobTasks(jobs), nil)
+	assert.Equal(t, []float64{1.82, 1.82, 0}, p.costs)
+	assert.InDelta(t, 3.64, p.total, 0.0001)
+	assert.Equal(t, 1, p.failed)
+}
+
+func TestCheckBalanceRefusesOrWarns(t *testing.T) {
+	svc := testTopupsServer(t)
+
+	err := checkBalance(context.Background(), svc, &projection{total: 4}, false)
+	assert.Nil(t, err)
+
+	err = checkBalance(context.Background(), svc, &projection{total: 6}, false)
+	assert.ErrorIs(t, err, reloadly.ErrInsufficientBalance)
+
+	err = checkBalance(context.Background(), svc, &projection{total: 6}, true)
+	assert.Nil(t, err)
+}
+
+func TestBatchTopupStopsAtMaxSpend(t *testing.T) {
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN"},
+		{Number: "+123", Amount: 100, Country: "IN"},
+		{Number: "+123", Amount: 100, Country: "IN"},
+	}
+
+	b := newBudget(4)
+	responses := collectResponses(streamBatchTopup(context.Background(), svc, 1, jobTasks(jobs), []float64{1.82, 1.82, 1.82}, b, nil))
+
+	codes := []string{}
+	for _, r := range responses {
+		codes = append(codes, r.ErrorCode)
+	}
+	assert.ElementsMatch(t, []string{"", "", "BUDGET_EXCEEDED"}, codes)
+	assert.InDelta(t, 3.64, b.total(), 0.0001)
+}
+
+func TestBatchDoesNotDetectOperatorsTwice(t *testing.T) {
+	dat, _ := ioutil.ReadFile("../reloadly/test/airtel.json")
+	airtel := string(dat)
+	ts, mux := reloadly.TestServerMux()
+
+	var detects int32
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&detects, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"transactionId": 1, "requestedAmount": 1.82, "requestedAmountCurrencyCode": "USD"}`)
+	})
+
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+
+	path := filepath.Join(t.TempDir(), "in.csv")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("number,amount,country,id\n+123,100,IN,a\n+123,100,IN,b\n"), 0644))
+	in := &batchInput{path: path, runID: "run1", idTemplate: DefaultIDTemplate, detected: newDetections()}
+
+	tasks, errs := in.tasks(context.Background())
+	p := projectSpend(context.Background(), svc, 1, tasks, in.detected)
+	assert.Nil(t, <-errs)
+	assert.Equal(t, []float64{1.82, 1.82}, p.costs)
+
+	tasks, errs = in.tasks(context.Background())
+	responses := collectResponses(streamBatchTopup(context.Background(), svc, 2, tasks, p.costs, nil, nil))
+	assert.Nil(t, <-errs)
+
+	for _, r := range responses {
+		assert.Equal(t, "", r.ErrorCode)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&detects))
+}
+
+func TestBudgetSettlesUnclassifiedFailuresAtProjectedCost(t *testing.T) {
+	dat, _ := ioutil.ReadFile("../reloadly/test/airtel.json")
+	airtel := string(dat)
+	ts, mux := reloadly.TestServerMux()
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"transactionId": `)
+	})
+
+	svc := reloadly.NewTopups()
+	svc.BaseUrl = ts.URL
+
+	jobs := []reloadly.TopupJob{{Number: "+123", Amount: 100, Country: "IN"}}
+	b := newBudget(10)
+	responses := collectResponses(streamBatchTopup(context.Background(), svc, 1, jobTasks(jobs), []float64{1.82}, b, nil))
+
+	assert.Equal(t, "", responses[0].ErrorCode)
+	assert.NotEqual(t, "", responses[0].ErrorMessage)
+	assert.False(t, succeeded(responses[0]))
+	assert.InDelta(t, 1.82, b.total(), 0.0001)
+}
@@ -34,7 +34,7 @@ var productsCmd = &cobra.Command{
 			return err
 		}
 
-		ps, err := svc.GiftCards().Products(page, size)
+		ps, err := svc.GiftCards().ProductsContext(cmd.Context(), page, size)
 		if err != nil {
 			return err
 		}
@@ -65,7 +65,7 @@ var productCmd = &cobra.Command{
 			return err
 		}
 
-		p, err := svc.GiftCards().Product(productId)
+		p, err := svc.GiftCards().ProductContext(cmd.Context(), productId)
 		if err != nil {
 			return err
 		}
@@ -93,7 +93,7 @@ var productsByCountryCmd = &cobra.Command{
 			return err
 		}
 
-		ps, err := svc.GiftCards().ProductsByCountry(country)
+		ps, err := svc.GiftCards().ProductsByCountryContext(cmd.Context(), country)
 		if err != nil {
 			return err
 		}
@@ -113,7 +113,7 @@ var redeemInstructionsCmd = &cobra.Command{
 			return err
 		}
 
-		ins, err := svc.GiftCards().RedeemInstructions()
+		ins, err := svc.GiftCards().RedeemInstructionsContext(cmd.Context())
 		if err != nil {
 			return err
 		}
@@ -144,7 +144,7 @@ var redeemInstructionsByBrandCmd = &cobra.Command{
 			return err
 		}
 
-		ins, err := svc.GiftCards().RedeemInstructionsByBrand(brandId)
+		ins, err := svc.GiftCards().RedeemInstructionsByBrandContext(cmd.Context(), brandId)
 		if err != nil {
 			return err
 		}
@@ -174,7 +174,7 @@ var discountsCmd = &cobra.Command{
 			return err
 		}
 
-		ds, err := svc.GiftCards().Discounts(page, size)
+		ds, err := svc.GiftCards().DiscountsContext(cmd.Context(), page, size)
 		if err != nil {
 			return err
 		}
@@ -205,7 +205,7 @@ var discountByProductCmd = &cobra.Command{
 			return err
 		}
 
-		d, err := svc.GiftCards().DiscountByProduct(productId)
+		d, err := svc.GiftCards().DiscountByProductContext(cmd.Context(), productId)
 		if err != nil {
 			return err
 		}
@@ -235,7 +235,7 @@ var transactionsCmd = &cobra.Command{
 			return err
 		}
 
-		ts, err := svc.GiftCards().Transactions(page, size)
+		ts, err := svc.GiftCards().TransactionsContext(cmd.Context(), page, size)
 		if err != nil {
 			return err
 		}
@@ -266,7 +266,7 @@ var transactionCmd = &cobra.Command{
 			return err
 		}
 
-		t, err := svc.GiftCards().Transaction(transactionId)
+		t, err := svc.GiftCards().TransactionContext(cmd.Context(), transactionId)
 		if err != nil {
 			return err
 		}
@@ -313,8 +313,16 @@ var orderGiftCardCmd = &cobra.Command{
 			return err
 		}
 
-		order := reloadly.GiftCardOrder{productId, countryCode, quantity, unitPrice, customIdentifier, senderName, recipientEmail, ""}
-		o, err := svc.GiftCards().Order(order)
+		order := reloadly.GiftCardOrder{
+			ProductID:        productId,
+			CountryCode:      countryCode,
+			Quantity:         quantity,
+			UnitPrice:        unitPrice,
+			CustomIdentifier: customIdentifier,
+			SenderName:       senderName,
+			RecipientEmail:   recipientEmail,
+		}
+		o, err := svc.GiftCards().OrderContext(cmd.Context(), order)
 		if err != nil {
 			return err
 		}
@@ -345,7 +353,7 @@ var getRedeemCodeCmd = &cobra.Command{
 			return err
 		}
 
-		r, err := svc.GiftCards().GetRedeemCode(transactionId)
+		r, err := svc.GiftCards().GetRedeemCodeContext(cmd.Context(), transactionId)
 		if err != nil {
 			return err
 		}
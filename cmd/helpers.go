@@ -3,9 +3,9 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/vlab-research/go-reloadly/reloadly"
 )
 
@@ -29,16 +29,66 @@ func loadService(cmd *cobra.Command, svc *reloadly.Service) (*reloadly.Service,
 		svc.Sandbox()
 	}
 
-	err = svc.Auth(os.Getenv("RELOADLY_ID"), os.Getenv("RELOADLY_SECRET"))
+	svc.Logger = logger
+
+	metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+	if err != nil {
+		return nil, err
+	}
+
+	if metricsAddr != "" {
+		svc.Metrics = reloadly.NewMetrics()
+		err = serveMetrics(metricsAddr, svc.Metrics)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retry, err := cmd.Flags().GetBool("retry")
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("Authorized with Reloadly")
+	if retry {
+		svc.RetryPolicy = reloadly.DefaultRetryPolicy()
+	}
+
+	source := tokenSource(svc)
+
+	noCache, err := cmd.Flags().GetBool("no-token-cache")
+	if err != nil {
+		return nil, err
+	}
+
+	if !noCache && viper.GetString("token_file") == "" {
+		cache, err := defaultTokenCache()
+		if err == nil {
+			source = cache.source(tokenCacheKey(svc.BaseUrl, viper.GetString("reloadly_id")), source)
+		}
+	}
+
+	err = svc.AuthWithContext(cmd.Context(), source)
+	if err != nil {
+		return nil, err
+	}
 
 	return svc, nil
 }
 
+// tokenSource picks where the CLI gets its tokens from: a token file
+// kept up to date by another process, if one is configured, or else
+// the client id and secret from the config file or environment
+// (reloadly_id/reloadly_secret or RELOADLY_ID/RELOADLY_SECRET).
+func tokenSource(svc *reloadly.Service) reloadly.TokenSource {
+	if path := viper.GetString("token_file"); path != "" {
+		return reloadly.FileToken(path)
+	}
+
+	return svc.CredentialsFrom(func() (string, string, error) {
+		return viper.GetString("reloadly_id"), viper.GetString("reloadly_secret"), nil
+	})
+}
+
 func PrettyPrint(object interface{}) error {
 	b, err := json.MarshalIndent(object, "", "  ")
 	if err != nil {
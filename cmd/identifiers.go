@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// DefaultIDTemplate is the template of the custom identifiers given
+// to batch rows that have none.
+const DefaultIDTemplate = "{{.RunID}}-{{.ID}}-{{.Hash}}"
+
+// identifierFields are the fields available to --id-template.
+type identifierFields struct {
+	RunID string
+
+	// ID is the row's id, or its (1-based) row number if it has none.
+	ID  string
+	Row int
+
+	// Hash is a short hash of the number, amount and country.
+	Hash string
+
+	Number   string
+	Amount   float64
+	Country  string
+	Operator string
+}
+
+func shortHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// fileRunID derives a run id from the contents of the input file, so
+// that running the same file again gives the same identifiers.
+func fileRunID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:8], nil
+}
+
+// identifiers gives every job without a custom identifier one made
+// from the template, which only depends on the run id and the job, so
+// that it is the same every time the batch is run. It remembers the
+// identifiers it has seen, to catch duplicates.
+type identifiers struct {
+	tmpl  *template.Template
+	runID string
+	seen  map[string]int
+}
+
+func newIdentifiers(runID, idTemplate string) (*identifiers, error) {
+	tmpl, err := template.New("id").Option("missingkey=error").Parse(idTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --id-template: %v", err)
+	}
+	return &identifiers{tmpl: tmpl, runID: runID, seen: map[string]int{}}, nil
+}
+
+// assign sets the custom identifier of the job at (0-based) index i,
+// if it has none, and fails if another job has the same one.
+func (ids *identifiers) assign(i int, j *reloadly.TopupJob) error {
+	if j.CustomIdentifier == "" {
+		f := identifierFields{
+			RunID:    ids.runID,
+			ID:       j.ID,
+			Row:      i + 1,
+			Hash:     shortHash([]byte(fmt.Sprintf("%v|%v|%v", j.Number, j.Amount, j.Country))),
+			Number:   j.Number,
+			Amount:   j.Amount,
+			Country:  j.Country,
+			Operator: j.Operator,
+		}
+		if f.ID == "" {
+			f.ID = fmt.Sprint(f.Row)
+		}
+
+		var b bytes.Buffer
+		err := ids.tmpl.Execute(&b, f)
+		if err != nil {
+			return fmt.Errorf("invalid --id-template: %v", err)
+		}
+		j.CustomIdentifier = b.String()
+	}
+
+	if row, ok := ids.seen[j.CustomIdentifier]; ok {
+		return fmt.Errorf("rows %v and %v have the same custom identifier: %v", row, i+1, j.CustomIdentifier)
+	}
+	ids.seen[j.CustomIdentifier] = i + 1
+	return nil
+}
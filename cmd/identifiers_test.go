@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func assignAll(jobs []reloadly.TopupJob, runID, idTemplate string) error {
+	ids, err := newIdentifiers(runID, idTemplate)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		err = ids.assign(i, &jobs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAssignIdentifiersIsDeterministic(t *testing.T) {
+	jobs := func() []reloadly.TopupJob {
+		return []reloadly.TopupJob{
+			{Number: "foo", Amount: 100, Country: "IN", ID: "a"},
+			{Number: "bar", Amount: 2.5, Country: "IN"},
+			{Number: "baz", Amount: 10, Country: "IN", CustomIdentifier: "mine"},
+		}
+	}
+
+	first, second := jobs(), jobs()
+	assert.Nil(t, assignAll(first, "run1", DefaultIDTemplate))
+	assert.Nil(t, assignAll(second, "run1", DefaultIDTemplate))
+
+	assert.Equal(t, first, second)
+	assert.Regexp(t, `^run1-a-[0-9a-f]{12}$`, first[0].CustomIdentifier)
+	assert.Regexp(t, `^run1-2-[0-9a-f]{12}$`, first[1].CustomIdentifier)
+	assert.Equal(t, "mine", first[2].CustomIdentifier)
+
+	other := jobs()
+	assert.Nil(t, assignAll(other, "run2", DefaultIDTemplate))
+	assert.NotEqual(t, first[0].CustomIdentifier, other[0].CustomIdentifier)
+}
+
+func TestAssignIdentifiersUsesTemplate(t *testing.T) {
+	jobs := []reloadly.TopupJob{{Number: "foo", Amount: 100, Country: "IN", ID: "42"}}
+
+	err := assignAll(jobs, "run1", "payout-{{.ID}}-{{.Country}}")
+	assert.Nil(t, err)
+	assert.Equal(t, "payout-42-IN", jobs[0].CustomIdentifier)
+}
+
+func TestAssignIdentifiersRefusesDuplicatesAndBadTemplates(t *testing.T) {
+	jobs := []reloadly.TopupJob{
+		{Number: "foo", Amount: 100, Country: "IN"},
+		{Number: "bar", Amount: 100, Country: "IN"},
+	}
+	err := assignAll(jobs, "run1", "payout-{{.Country}}")
+	assert.Contains(t, err.Error(), "rows 1 and 2")
+
+	jobs = []reloadly.TopupJob{{Number: "foo", Amount: 100, Country: "IN"}}
+	err = assignAll(jobs, "run1", "payout-{{.Nope}}")
+	assert.Contains(t, err.Error(), "invalid --id-template")
+}
+
+func TestFileRunIDDependsOnContents(t *testing.T) {
+	a, err := fileRunID("test/batch-full.csv")
+	assert.Nil(t, err)
+	b, _ := fileRunID("test/batch-full.csv")
+	c, _ := fileRunID("test/batch-missing-rows.csv")
+
+	assert.Equal(t, 8, len(a))
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+}
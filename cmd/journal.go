@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// journalEntry is a line of the journal: the response of a single
+// batch row, identified by its custom identifier.
+type journalEntry struct {
+	RunID            string                        `json:"runId"`
+	CustomIdentifier string                        `json:"customIdentifier"`
+	Time             time.Time                     `json:"time"`
+	Response         *reloadly.TopupWorkerResponse `json:"response"`
+}
+
+// journal appends the response of every batch row to a JSONL file as
+// soon as it is done, so that a batch that is killed can be resumed
+// without sending the rows that went through again.
+type journal struct {
+	mu    sync.Mutex
+	f     *os.File
+	runID string
+}
+
+func openJournal(path, runID string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f, runID: runID}, nil
+}
+
+// record writes a line for the job's response. It does nothing on a
+// nil journal.
+func (j *journal) record(job *reloadly.TopupJob, res *reloadly.TopupWorkerResponse) error {
+	if j == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(journalEntry{
+		RunID:            j.runID,
+		CustomIdentifier: job.CustomIdentifier,
+		Time:             time.Now(),
+		Response:         res,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.f.Write(append(b, '\n'))
+	return err
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// succeeded reports whether res is the response of a topup that went
+// through. Failed responses always have an error message, but not
+// always an error code.
+func succeeded(res *reloadly.TopupWorkerResponse) bool {
+	return res != nil && res.TopupResponse != nil && res.ErrorMessage == ""
+}
+
+// readJournal returns the successful responses of the given run in
+// the journal at path, by custom identifier. A missing journal has
+// none. Lines that cannot be parsed, like one cut short when the
+// batch was killed, are skipped.
+func readJournal(path, runID string) (map[string]*reloadly.TopupWorkerResponse, error) {
+	completed := map[string]*reloadly.TopupWorkerResponse{}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return completed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e journalEntry
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			logger.Warn("skipping unreadable journal line", "journal", path, "line", line, "error", err)
+			continue
+		}
+
+		if e.RunID != runID || e.CustomIdentifier == "" || !succeeded(e.Response) {
+			continue
+		}
+		completed[e.CustomIdentifier] = e.Response
+	}
+	return completed, scanner.Err()
+}
+
+// completedSpend is what the completed responses of a previous run
+// were charged.
+func completedSpend(completed map[string]*reloadly.TopupWorkerResponse) float64 {
+	spent := 0.0
+	for _, res := range completed {
+		if succeeded(res) {
+			spent += res.RequestedAmount
+		}
+	}
+	return spent
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+func TestBatchTopupWritesJournalAndResumes(t *testing.T) {
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN", CustomIdentifier: "row-1"},
+		{Number: "+456", Amount: 100, Country: "IN", CustomIdentifier: "row-2"},
+		{Number: "+123", Amount: 100, Country: "IN", CustomIdentifier: "row-3"},
+	}
+
+	path := filepath.Join(t.TempDir(), "out.csv.journal.jsonl")
+	j, err := openJournal(path, "run1")
+	assert.Nil(t, err)
+	collectResponses(streamBatchTopup(context.Background(), svc, 2, jobTasks(jobs[:2]), nil, nil, j))
+	assert.Nil(t, j.Close())
+
+	completed, err := readJournal(path, "run1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(completed))
+	assert.Equal(t, int64(1), completed["row-1"].TransactionID)
+	assert.Equal(t, "row-1", completed["row-1"].CustomIdentifier)
+
+	// Resuming passes the completed row through without sending it.
+	tasks := make(chan interface{}, len(jobs))
+	for i := range jobs {
+		tasks <- batchTask{i: i, job: &jobs[i], done: completed[jobs[i].CustomIdentifier]}
+	}
+	close(tasks)
+
+	j, err = openJournal(path, "run1")
+	assert.Nil(t, err)
+	responses := collectResponses(inOrder(streamBatchTopup(context.Background(), svc, 2, tasks, nil, nil, j)))
+	assert.Nil(t, j.Close())
+
+	assert.Equal(t, 3, len(responses))
+	assert.Equal(t, 1.82, responses[0].RequestedAmount)
+	assert.Equal(t, 1, responses[0].Row)
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, 4, len(strings.Split(strings.TrimSpace(string(b)), "\n")))
+
+	other, err := readJournal(path, "run2")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(other))
+}
+
+func TestReadJournalSkipsCutShortLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := `{"runId": "run1", "customIdentifier": "row-1", "response": {"transactionId": 1, "customIdentifier": "row-1"}}
+{"runId": "run1", "customIdentifier": "row-2", "response": {"errorCode": "COULD_NOT_AUTO_DETECT_OPERATOR"}}
+{"runId": "run1", "customIdentifier": "row-3", "respo`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(lines), 0644))
+
+	completed, err := readJournal(path, "run1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(completed))
+	assert.Contains(t, completed, "row-1")
+}
+
+func TestReadJournalWithoutJournal(t *testing.T) {
+	completed, err := readJournal(filepath.Join(t.TempDir(), "nope.jsonl"), "run1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(completed))
+}
+
+func TestResumedBudgetCountsCompletedSpend(t *testing.T) {
+	completed := map[string]*reloadly.TopupWorkerResponse{
+		"row-1": {TopupResponse: &reloadly.TopupResponse{RequestedAmount: 1.5}},
+		"row-2": {TopupResponse: &reloadly.TopupResponse{RequestedAmount: 2}},
+		"row-3": {},
+	}
+	assert.Equal(t, 3.5, completedSpend(completed))
+
+	b := newBudget(5)
+	b.settle(0, completedSpend(completed))
+	assert.True(t, b.reserve(1.5))
+	assert.False(t, b.reserve(0.5))
+}
+
+func TestResumeRetriesFailuresWithoutErrorCode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	lines := `{"runId": "run1", "customIdentifier": "row-1", "response": {"transactionId": 1, "requestedAmount": 1.82, "customIdentifier": "row-1"}}
+{"runId": "run1", "customIdentifier": "row-2", "response": {"requestedAmount": 100, "customIdentifier": "row-2", "errorMessage": "invalid character '<' looking for beginning of value"}}
+`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(lines), 0644))
+
+	completed, err := readJournal(path, "run1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(completed))
+	assert.NotContains(t, completed, "row-2")
+	assert.Equal(t, 1.82, completedSpend(completed))
+
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN", CustomIdentifier: "row-1"},
+		{Number: "+123", Amount: 100, Country: "IN", CustomIdentifier: "row-2"},
+	}
+	tasks := make(chan interface{}, len(jobs))
+	for i := range jobs {
+		tasks <- batchTask{i: i, job: &jobs[i], done: completed[jobs[i].CustomIdentifier]}
+	}
+	close(tasks)
+
+	// row-1 is passed through, and row-2 is sent again.
+	responses := collectResponses(inOrder(streamBatchTopup(context.Background(), svc, 1, tasks, nil, nil, nil)))
+	assert.Equal(t, 2, len(responses))
+	assert.True(t, responses[0] == completed["row-1"])
+	assert.Equal(t, "", responses[1].ErrorMessage)
+	assert.Equal(t, int64(1), responses[1].TransactionID)
+}
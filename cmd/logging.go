@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// logger writes the CLI's (and the library's) logs to stderr, so
+// that stdout only carries command output.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %v, expected debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %v, expected json or text", format)
+	}
+}
+
+func initLogger() {
+	l, err := newLogger(os.Stderr, viper.GetString("log_level"), viper.GetString("log_format"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logger = l
+
+	if configFile != "" {
+		logger.Info("using config file", "path", configFile)
+	}
+}
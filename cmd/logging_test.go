@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoggerRespectsLevelAndFormat(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := newLogger(buf, "warn", "json")
+	assert.Nil(t, err)
+
+	l.Info("hidden")
+	l.Warn("shown", "foo", "bar")
+
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), `"msg":"shown","foo":"bar"`)
+}
+
+func TestNewLoggerRejectsUnknownLevelOrFormat(t *testing.T) {
+	_, err := newLogger(new(bytes.Buffer), "loud", "text")
+	assert.NotNil(t, err)
+
+	_, err = newLogger(new(bytes.Buffer), "info", "xml")
+	assert.NotNil(t, err)
+}
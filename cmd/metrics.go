@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// serveMetrics registers metrics with a new registry and serves it
+// on addr at /metrics, for as long as the command runs. It listens
+// before returning, so that a bad address fails the command early.
+func serveMetrics(addr string, metrics *reloadly.Metrics) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(metrics); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	go func() {
+		err := http.Serve(ln, mux)
+		logger.Error("metrics server stopped", "error", err)
+	}()
+
+	logger.Info("serving metrics", "addr", ln.Addr().String())
+	return nil
+}
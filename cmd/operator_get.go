@@ -25,7 +25,7 @@ var operatorGetCmd = &cobra.Command{
 			return err
 		}
 
-		operator, err := svc.Topups().SearchOperator(country, operatorName)
+		operator, err := svc.Topups().SearchOperatorContext(cmd.Context(), country, operatorName)
 		if err != nil {
 			return err
 		}
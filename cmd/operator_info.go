@@ -29,7 +29,7 @@ var operatorInfoCmd = &cobra.Command{
 			return err
 		}
 
-		operator, err := svc.Topups().GetOperatorByID(operatorID)
+		operator, err := svc.Topups().GetOperatorByIDContext(cmd.Context(), operatorID)
 		if err != nil {
 			return err
 		}
@@ -24,7 +24,7 @@ var operatorListCmd = &cobra.Command{
 			return err
 		}
 
-		operators, err := svc.Topups().OperatorsByCountry(country)
+		operators, err := svc.Topups().OperatorsByCountryContext(cmd.Context(), country)
 		if err != nil {
 			return err
 		}
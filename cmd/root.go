@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
@@ -11,6 +14,9 @@ import (
 )
 
 var cfgFile string
+
+// configFile is the config file that was read, if any.
+var configFile string
 var rootCmd = &cobra.Command{
 	Use:   "reloadly",
 	Short: "go-reloadly is a tool for managing Reloadly services",
@@ -20,17 +26,37 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	// Cancel in-flight requests on SIGINT/SIGTERM so that
+	// long-running commands (e.g. batch) can stop cleanly. After the
+	// first signal the default handling is back, so a second one
+	// kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, initLogger)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.reloadly.yaml)")
 	rootCmd.PersistentFlags().BoolP("sandbox", "s", false, "run as sandbox")
+	rootCmd.PersistentFlags().String("token-file", "", "read the access token from this JSON file instead of using a client id and secret")
+	viper.BindPFlag("token_file", rootCmd.PersistentFlags().Lookup("token-file"))
+	rootCmd.PersistentFlags().Bool("no-token-cache", false, "do not read or write the cached access token")
+	rootCmd.PersistentFlags().Bool("retry", false, "retry requests that fail with transient errors")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "serve Prometheus metrics on this address (e.g. :9090) while the command runs")
+	rootCmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level"))
+	rootCmd.PersistentFlags().String("log-format", "text", "log format: json or text")
+	viper.BindPFlag("log_format", rootCmd.PersistentFlags().Lookup("log-format"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -42,7 +68,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
@@ -55,6 +81,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		configFile = viper.ConfigFileUsed()
 	}
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 
 	"github.com/spf13/cobra"
@@ -44,28 +43,67 @@ var singleCmd = &cobra.Command{
 			return err
 		}
 
+		customIdentifier, err := cmd.Flags().GetString("custom-identifier")
+		if err != nil {
+			return err
+		}
+
+		idempotent, err := cmd.Flags().GetBool("idempotent")
+		if err != nil {
+			return err
+		}
+
+		if idempotent && customIdentifier == "" {
+			return errors.New("--idempotent requires --custom-identifier")
+		}
+
+		t := svc.Topups()
+		if customIdentifier != "" {
+			t = t.CustomIdentifier(customIdentifier)
+		}
+		if idempotent {
+			t = t.Idempotent()
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			if operatorName != "" {
+				t = t.FindOperatorContext(cmd.Context(), country, operatorName)
+			} else {
+				t = t.AutoDetect(country)
+			}
+
+			q, err := t.SuggestedAmount(tolerance).QuoteContext(cmd.Context(), number, amount)
+			if err != nil {
+				logger.Error("quote failed", "error", err)
+				return nil
+			}
+			return PrettyPrint(q)
+		}
+
 		var res *reloadly.TopupResponse
 
 		if operatorName != "" {
-			fmt.Println(fmt.Sprintf("Using operator: %v", operatorName))
-			res, err = svc.Topups().FindOperator(country, operatorName).SuggestedAmount(tolerance).AutoFallback().Topup(number, amount)
+			logger.Info("using operator", "operator", operatorName)
+			res, err = t.FindOperatorContext(cmd.Context(), country, operatorName).SuggestedAmount(tolerance).AutoFallback().TopupContext(cmd.Context(), number, amount)
 		} else {
-			t := svc.Topups()
-			res, err = t.AutoDetect(country).SuggestedAmount(tolerance).Topup(number, amount)
+			res, err = t.AutoDetect(country).SuggestedAmount(tolerance).TopupContext(cmd.Context(), number, amount)
 			if err == nil {
-				fmt.Println(fmt.Sprintf("Autodetected operator: %v", t.GetSetOperator().Name))
+				logger.Info("auto-detected operator", "operator", t.GetSetOperator().Name)
 			}
 
 		}
 
 		if err != nil {
-			fmt.Println(err)
+			logger.Error("topup failed", "error", err)
 			return nil
 		}
 
-		fmt.Printf("Topup response: %v", res)
-
-		return nil
+		return PrettyPrint(res)
 	},
 }
 
@@ -74,4 +112,7 @@ func init() {
 
 	singleCmd.Flags().Float64P("tolerance", "t", 0.0, "tolerance for topup")
 	singleCmd.Flags().String("operator", "", "operator")
+	singleCmd.Flags().String("custom-identifier", "", "custom identifier sent with the topup")
+	singleCmd.Flags().Bool("dry-run", false, "Print what the topup would send and cost, without sending it")
+	singleCmd.Flags().Bool("idempotent", false, "Do not send the topup if one with the same --custom-identifier is in the transactions report")
 }
@@ -0,0 +1,422 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/jszwec/csvutil"
+	"github.com/nandanrao/chance"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// batchTask is a job of a batch, with its (0-based) index in the
+// input.
+type batchTask struct {
+	i   int
+	job *reloadly.TopupJob
+
+	// done, if set, is the response of the job from a previous
+	// run, so it is not sent again.
+	done *reloadly.TopupWorkerResponse
+}
+
+// jobTasks sends a batchTask for every job, in order.
+func jobTasks(jobs []reloadly.TopupJob) <-chan interface{} {
+	tt := make([]interface{}, len(jobs))
+	for i := range jobs {
+		tt[i] = batchTask{i: i, job: &jobs[i]}
+	}
+	return chance.Flatten(tt)
+}
+
+var errEmptyBatch = fmt.Errorf("We could not parse the data from the csv. Please ensure it is in the right format and that the required fields (number, amount, country) are present for each row in the csv file.")
+
+// readBatchCsv decodes and validates the rows of a batch csv one at a
+// time, calling fn with each row and its (0-based) index.
+func readBatchCsv(r io.Reader, fn func(i int, job reloadly.TopupJob) error) error {
+	dec, err := csvutil.NewDecoder(csv.NewReader(r))
+	if err == io.EOF {
+		return errEmptyBatch
+	}
+	if err != nil {
+		return err
+	}
+
+	validate := validator.New()
+	i := 0
+	for ; ; i++ {
+		var job reloadly.TopupJob
+		err = dec.Decode(&job)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = validate.Struct(job)
+		if err != nil {
+			return err
+		}
+
+		err = fn(i, job)
+		if err != nil {
+			return err
+		}
+	}
+
+	if i == 0 {
+		return errEmptyBatch
+	}
+	return nil
+}
+
+// readBatchJSONL decodes and validates the lines of a batch in JSON
+// Lines one at a time, like readBatchCsv. Blank lines are skipped.
+func readBatchJSONL(r io.Reader, fn func(i int, job reloadly.TopupJob) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	validate := validator.New()
+	i := 0
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var job reloadly.TopupJob
+		err := json.Unmarshal(b, &job)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", line, err)
+		}
+
+		err = validate.Struct(job)
+		if err != nil {
+			return fmt.Errorf("line %v: %v", line, err)
+		}
+
+		err = fn(i, job)
+		if err != nil {
+			return err
+		}
+		i++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if i == 0 {
+		return errEmptyBatch
+	}
+	return nil
+}
+
+// readBatch reads a batch in the given format, csv or jsonl.
+func readBatch(r io.Reader, format string, fn func(i int, job reloadly.TopupJob) error) error {
+	if format == "jsonl" {
+		return readBatchJSONL(r, fn)
+	}
+	return readBatchCsv(r, fn)
+}
+
+// fileFormat is "jsonl" for .jsonl and .ndjson files, and "csv" for
+// anything else.
+func fileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	default:
+		return "csv"
+	}
+}
+
+// batchFormat returns the format of the batch file at path: format,
+// if set, or else the one its extension implies, or fallback for
+// stdin and stdout ("-").
+func batchFormat(path, format, fallback string) (string, error) {
+	switch {
+	case format == "csv" || format == "jsonl":
+		return format, nil
+	case format != "":
+		return "", fmt.Errorf("unknown format %v, expected csv or jsonl", format)
+	case path == "-":
+		return fallback, nil
+	default:
+		return fileFormat(path), nil
+	}
+}
+
+// openBatch opens the batch file at path, or stdin for "-".
+func openBatch(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// spoolStdin copies stdin to a temporary file if path is "-", so that
+// it can be read more than once, and returns the file's path and a
+// func that removes it. Other paths are returned as they are.
+func spoolStdin(path string) (string, func(), error) {
+	if path != "-" {
+		return path, func() {}, nil
+	}
+
+	f, err := ioutil.TempFile("", "reloadly-batch-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	remove := func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, os.Stdin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return f.Name(), remove, nil
+}
+
+// batchInput reads the jobs of a batch from a csv or jsonl file, one
+// row at a time, so that the file can be read as many times as needed
+// without holding it in memory.
+type batchInput struct {
+	path       string
+	format     string
+	runID      string
+	idTemplate string
+
+	// completed holds the responses of a previous run, by custom
+	// identifier.
+	completed map[string]*reloadly.TopupWorkerResponse
+
+	// detected, if set, holds the operators already auto-detected
+	// for the numbers of the input, which are given to the jobs.
+	detected *detections
+
+	// drop, if set, forgets the completed response and detected
+	// operator of each row once it is read, so that the last read of
+	// the input frees them as it goes. A later row with the same
+	// number then has its operator detected again.
+	drop bool
+}
+
+// tasks sends a batchTask for every row of the input, in order, with
+// a custom identifier, and closes the channel when done. The error
+// that stopped the reading, if any, is then sent on the second
+// channel.
+func (in *batchInput) tasks(ctx context.Context) (<-chan interface{}, <-chan error) {
+	tasks := make(chan interface{})
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(tasks)
+		errs <- in.read(ctx, tasks)
+	}()
+
+	return tasks, errs
+}
+
+func (in *batchInput) read(ctx context.Context, tasks chan<- interface{}) error {
+	ids, err := newIdentifiers(in.runID, in.idTemplate)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(in.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return readBatch(bufio.NewReader(f), in.format, func(i int, job reloadly.TopupJob) error {
+		err := ids.assign(i, &job)
+		if err != nil {
+			return err
+		}
+
+		if job.Operator == "" {
+			job.DetectedOperator = in.detected.get(job.Number, job.Country, in.drop)
+		}
+
+		t := batchTask{i: i, job: &job, done: in.completed[job.CustomIdentifier]}
+		if in.drop {
+			delete(in.completed, job.CustomIdentifier)
+		}
+		select {
+		case tasks <- t:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// count reads the whole input, checking every row, and returns how
+// many there are.
+func (in *batchInput) count(ctx context.Context) (int, error) {
+	tasks, errs := in.tasks(ctx)
+	n := 0
+	for range tasks {
+		n++
+	}
+	return n, <-errs
+}
+
+// responseRow returns the row of a batch response or quote.
+func responseRow(r interface{}) int {
+	switch r := r.(type) {
+	case *reloadly.TopupWorkerResponse:
+		return r.Row
+	case *reloadly.TopupWorkerQuote:
+		return r.Row
+	}
+	return 0
+}
+
+// inOrder passes on the responses of a batch in the order of their
+// rows, holding back those that are done before an earlier row.
+func inOrder(responses <-chan interface{}) <-chan interface{} {
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+
+		pending := map[int]interface{}{}
+		next := 1
+		for res := range responses {
+			pending[responseRow(res)] = res
+
+			for res, ok := pending[next]; ok; res, ok = pending[next] {
+				out <- res
+				delete(pending, next)
+				next++
+			}
+		}
+
+		// Anything left means some rows had no response, which
+		// should not happen, but write it anyway.
+		rows := make([]int, 0, len(pending))
+		for row := range pending {
+			rows = append(rows, row)
+		}
+		sort.Ints(rows)
+		for _, row := range rows {
+			out <- pending[row]
+		}
+	}()
+
+	return out
+}
+
+// responseWriter appends batch responses (or quotes) to the output
+// as they are done, flushing each one.
+type responseWriter interface {
+	Write(interface{}) error
+	Close() error
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createResponseWriter creates the output at path, or writes to
+// stdout for "-". The csv header is taken from empty, a zero value of
+// what is written.
+func createResponseWriter(path, format string, empty interface{}) (responseWriter, error) {
+	if path == "-" {
+		return newResponseWriter(nopWriteCloser{os.Stdout}, format, empty), nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newResponseWriter(f, format, empty), nil
+}
+
+func newResponseWriter(w io.WriteCloser, format string, empty interface{}) responseWriter {
+	if format == "jsonl" {
+		bw := bufio.NewWriter(w)
+		return &jsonlResponseWriter{c: w, w: bw, enc: json.NewEncoder(bw)}
+	}
+
+	cw := csv.NewWriter(w)
+	return &csvResponseWriter{c: w, w: cw, enc: csvutil.NewEncoder(cw), empty: empty}
+}
+
+type csvResponseWriter struct {
+	c     io.Closer
+	w     *csv.Writer
+	enc   *csvutil.Encoder
+	empty interface{}
+	n     int
+}
+
+func (r *csvResponseWriter) Write(res interface{}) error {
+	err := r.enc.Encode(res)
+	if err != nil {
+		return err
+	}
+	r.n++
+
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// Close writes the header if there were no responses, so that the
+// output is a valid csv either way.
+func (r *csvResponseWriter) Close() error {
+	if r.n == 0 {
+		err := r.enc.EncodeHeader(r.empty)
+		if err != nil {
+			r.c.Close()
+			return err
+		}
+	}
+
+	r.w.Flush()
+	err := r.w.Error()
+	if cerr := r.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type jsonlResponseWriter struct {
+	c   io.Closer
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (r *jsonlResponseWriter) Write(res interface{}) error {
+	err := r.enc.Encode(res)
+	if err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+func (r *jsonlResponseWriter) Close() error {
+	err := r.w.Flush()
+	if cerr := r.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// jobTasks sends a batchTask for each of jobs.
+func collectResponses(responses <-chan interface{}) []*reloadly.TopupWorkerResponse {
+	res := []*reloadly.TopupWorkerResponse{}
+	for r := range responses {
+		res = append(res, r.(*reloadly.TopupWorkerResponse))
+	}
+	return res
+}
+
+func TestBatchInputStreamsTasksWithIdentifiers(t *testing.T) {
+	in := &batchInput{
+		path:       "test/batch-full.csv",
+		runID:      "run1",
+		idTemplate: "{{.RunID}}-{{.Row}}",
+		completed:  map[string]*reloadly.TopupWorkerResponse{"run1-2": {ErrorCode: ""}},
+	}
+
+	tasks, errs := in.tasks(context.Background())
+	got := []batchTask{}
+	for task := range tasks {
+		got = append(got, task.(batchTask))
+	}
+
+	assert.Nil(t, <-errs)
+	assert.Equal(t, 2, len(got))
+	assert.Equal(t, "run1-1", got[0].job.CustomIdentifier)
+	assert.Nil(t, got[0].done)
+	assert.Equal(t, 1, got[1].i)
+	assert.NotNil(t, got[1].done)
+}
+
+func TestBatchInputDropsRowsOnItsLastRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.csv")
+	err := ioutil.WriteFile(path, []byte("number,amount,country\n+123,100,IN\n+456,100,IN\n"), 0644)
+	assert.Nil(t, err)
+
+	det := newDetections()
+	det.put("+123", "IN", &reloadly.Operator{OperatorID: 1})
+	in := &batchInput{
+		path:       path,
+		runID:      "run1",
+		idTemplate: "{{.RunID}}-{{.Row}}",
+		completed:  map[string]*reloadly.TopupWorkerResponse{"run1-2": {ErrorCode: ""}},
+		detected:   det,
+		drop:       true,
+	}
+
+	tasks, errs := in.tasks(context.Background())
+	got := []batchTask{}
+	for task := range tasks {
+		got = append(got, task.(batchTask))
+	}
+
+	assert.Nil(t, <-errs)
+	assert.Equal(t, int64(1), got[0].job.DetectedOperator.OperatorID)
+	assert.NotNil(t, got[1].done)
+	assert.Empty(t, in.completed)
+	assert.Empty(t, det.numbers)
+}
+
+func TestBatchInputCountFailsOnInvalidRow(t *testing.T) {
+	in := &batchInput{path: "test/batch-missing-required.csv", runID: "run1", idTemplate: DefaultIDTemplate}
+
+	_, err := in.count(context.Background())
+	assert.NotNil(t, err)
+
+	in = &batchInput{path: "test/batch-full.csv", runID: "run1", idTemplate: "{{.Country}}"}
+	_, err = in.count(context.Background())
+	assert.Contains(t, err.Error(), "rows 1 and 2")
+}
+
+func TestStreamBatchTopupWritesEachResponse(t *testing.T) {
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN", CustomIdentifier: "row-1"},
+		{Number: "+456", Amount: 100, Country: "IN", CustomIdentifier: "row-2"},
+	}
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	out, err := createResponseWriter(path, "csv", reloadly.TopupWorkerResponse{})
+	assert.Nil(t, err)
+
+	lines := []int{}
+	for r := range streamBatchTopup(context.Background(), svc, 1, jobTasks(jobs), nil, nil, nil) {
+		assert.Nil(t, out.Write(r.(*reloadly.TopupWorkerResponse)))
+
+		// Flushed as it goes, header included.
+		b, _ := ioutil.ReadFile(path)
+		lines = append(lines, len(strings.Split(strings.TrimSpace(string(b)), "\n")))
+	}
+	assert.Nil(t, out.Close())
+	assert.Equal(t, []int{2, 3}, lines)
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(b), "COULD_NOT_AUTO_DETECT_OPERATOR")
+	assert.Contains(t, string(b), "row-1")
+}
+
+func TestResponseWriterWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	out, err := createResponseWriter(path, "jsonl", reloadly.TopupWorkerResponse{})
+	assert.Nil(t, err)
+
+	res := &reloadly.TopupWorkerResponse{TopupResponse: &reloadly.TopupResponse{TransactionID: 1}}
+	assert.Nil(t, out.Write(res))
+	assert.Nil(t, out.Write(res))
+	assert.Nil(t, out.Close())
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "{\"transactionId\":1}\n{\"transactionId\":1}\n", string(b))
+}
+
+func TestResponseWriterWritesHeaderWithoutResponses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	out, err := createResponseWriter(path, "csv", reloadly.TopupWorkerResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, out.Close())
+
+	b, _ := ioutil.ReadFile(path)
+	assert.True(t, strings.HasPrefix(string(b), "row,id,transactionId,"))
+}
+
+func TestInOrderHoldsBackLaterRows(t *testing.T) {
+	responses := make(chan interface{}, 4)
+	for _, row := range []int{3, 1, 4, 2} {
+		responses <- &reloadly.TopupWorkerResponse{Row: row}
+	}
+	close(responses)
+
+	rows := []int{}
+	for r := range inOrder(responses) {
+		rows = append(rows, r.(*reloadly.TopupWorkerResponse).Row)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4}, rows)
+}
+
+func TestStreamBatchTopupLinksResponsesToRows(t *testing.T) {
+	svc := testTopupsServer(t)
+	jobs := []reloadly.TopupJob{
+		{Number: "+123", Amount: 100, Country: "IN", ID: "a", CustomIdentifier: "row-1"},
+		{Number: "+456", Amount: 100, Country: "IN", ID: "b", CustomIdentifier: "row-2"},
+		{Number: "+123", Amount: 100, Country: "IN", ID: "c", CustomIdentifier: "row-3"},
+	}
+
+	got := []*reloadly.TopupWorkerResponse{}
+	for r := range inOrder(streamBatchTopup(context.Background(), svc, 3, jobTasks(jobs), nil, nil, nil)) {
+		got = append(got, r.(*reloadly.TopupWorkerResponse))
+	}
+
+	for i, res := range got {
+		assert.Equal(t, i+1, res.Row)
+		assert.Equal(t, jobs[i].ID, res.JobID)
+		assert.Equal(t, jobs[i].CustomIdentifier, res.CustomIdentifier)
+	}
+	assert.Equal(t, "COULD_NOT_AUTO_DETECT_OPERATOR", got[1].ErrorCode)
+}
+
+func TestQuoteBatchWritesQuotes(t *testing.T) {
+	svc := testTopupsServer(t)
+	in := &batchInput{path: "test/batch-quote.csv", runID: "run1", idTemplate: DefaultIDTemplate}
+
+	path := filepath.Join(t.TempDir(), "quotes.jsonl")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, quoteBatch(ctx, cancel, svc, in, path, "jsonl", 2, true))
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Contains(t, lines[0], `"row":1,"id":"a"`)
+	assert.Contains(t, lines[0], `"amount":1.82`)
+	assert.Contains(t, lines[0], `"deliveredAmount":100`)
+	assert.Contains(t, lines[1], `"errorCode":"COULD_NOT_AUTO_DETECT_OPERATOR"`)
+	assert.Contains(t, lines[2], `"row":3`)
+}
+
+func TestReadBatchJSONLCastsNumbers(t *testing.T) {
+	jobs := []reloadly.TopupJob{}
+	in := &batchInput{path: "test/batch.jsonl", format: "jsonl", runID: "run1", idTemplate: DefaultIDTemplate}
+
+	tasks, errs := in.tasks(context.Background())
+	for task := range tasks {
+		jobs = append(jobs, *task.(batchTask).job)
+	}
+
+	assert.Nil(t, <-errs)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "919876543210", jobs[0].Number)
+	assert.Equal(t, "1", jobs[0].ID)
+	assert.Equal(t, "+919876543211", jobs[1].Number)
+}
+
+func TestReadBatchJSONLFailsOnInvalidLine(t *testing.T) {
+	err := readBatchJSONL(strings.NewReader("{\"number\": \"+123\", \"amount\": 1, \"country\": \"IN\"}\n{\"number\": \"+123\"}\n"), func(int, reloadly.TopupJob) error { return nil })
+	assert.Contains(t, err.Error(), "line 2")
+
+	err = readBatchJSONL(strings.NewReader("\n"), func(int, reloadly.TopupJob) error { return nil })
+	assert.Equal(t, errEmptyBatch, err)
+}
+
+func TestBatchFormat(t *testing.T) {
+	for _, tc := range []struct {
+		path, format, fallback, want string
+	}{
+		{"in.csv", "", "csv", "csv"},
+		{"in.jsonl", "", "csv", "jsonl"},
+		{"in.NDJSON", "", "csv", "jsonl"},
+		{"in.jsonl", "csv", "csv", "csv"},
+		{"-", "", "jsonl", "jsonl"},
+		{"-", "jsonl", "csv", "jsonl"},
+	} {
+		got, err := batchFormat(tc.path, tc.format, tc.fallback)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.want, got, tc.path)
+	}
+
+	_, err := batchFormat("in.csv", "xml", "csv")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// parseDate parses a date given on the command line, with or
+// without a time of day.
+func parseDate(s string) (time.Time, error) {
+	for _, format := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+		t, err := time.Parse(format, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse date %v, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", s)
+}
+
+// parseEndDate parses the end of a date range, which includes the
+// whole of its last day if no time of day is given.
+func parseEndDate(s string) (time.Time, error) {
+	t, err := parseDate(s)
+	if err != nil {
+		return t, err
+	}
+
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func topupTransactionsFilter(cmd *cobra.Command) (reloadly.TopupTransactionsFilter, error) {
+	f := reloadly.TopupTransactionsFilter{}
+	flags := cmd.Flags()
+
+	var err error
+	if f.Page, err = flags.GetInt64("page"); err != nil {
+		return f, err
+	}
+	if f.Size, err = flags.GetInt64("size"); err != nil {
+		return f, err
+	}
+	if f.CountryCode, err = flags.GetString("country"); err != nil {
+		return f, err
+	}
+	if f.OperatorID, err = flags.GetInt64("operator-id"); err != nil {
+		return f, err
+	}
+	if f.OperatorName, err = flags.GetString("operator"); err != nil {
+		return f, err
+	}
+	if f.CustomIdentifier, err = flags.GetString("custom-identifier"); err != nil {
+		return f, err
+	}
+	if f.RecipientPhone, err = flags.GetString("phone"); err != nil {
+		return f, err
+	}
+
+	dates := []struct {
+		flag  string
+		date  *time.Time
+		parse func(string) (time.Time, error)
+	}{
+		{"from", &f.StartDate, parseDate},
+		{"to", &f.EndDate, parseEndDate},
+	}
+
+	for _, d := range dates {
+		s, err := flags.GetString(d.flag)
+		if err != nil {
+			return f, err
+		}
+		if s == "" {
+			continue
+		}
+		if *d.date, err = d.parse(s); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+var topupTransactionsCmd = &cobra.Command{
+	Use:   "transactions",
+	Short: "Fetch the topup transactions report",
+	Long:  "Fetch the topup transactions report, optionally filtered by date, operator, country, custom identifier or phone",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := topupTransactionsFilter(cmd)
+		if err != nil {
+			return err
+		}
+
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
+		svc, err := LoadTopupsService(cmd)
+		if err != nil {
+			return err
+		}
+
+		if all {
+			ts, err := svc.Topups().AllTransactionsContext(cmd.Context(), filter)
+			if err != nil {
+				return err
+			}
+			return PrettyPrint(ts)
+		}
+
+		ts, err := svc.Topups().TransactionsContext(cmd.Context(), filter)
+		if err != nil {
+			return err
+		}
+		return PrettyPrint(ts)
+	},
+}
+
+var topupTransactionCmd = &cobra.Command{
+	Use:   "transaction",
+	Short: "Fetch the details of a particular topup",
+	Long:  "Fetch the details of a particular topup",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires transaction id")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		transactionID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+
+		svc, err := LoadTopupsService(cmd)
+		if err != nil {
+			return err
+		}
+
+		t, err := svc.Topups().TransactionContext(cmd.Context(), transactionID)
+		if err != nil {
+			return err
+		}
+		return PrettyPrint(t)
+	},
+}
+
+func init() {
+	topupsCmd.AddCommand(topupTransactionsCmd)
+	topupsCmd.AddCommand(topupTransactionCmd)
+
+	flags := topupTransactionsCmd.Flags()
+	flags.Int64("page", 1, "page number")
+	flags.Int64("size", 10, "max number of results to return")
+	flags.Bool("all", false, "fetch every page, starting from --page")
+	flags.String("country", "", "only transactions to this country code")
+	flags.Int64("operator-id", 0, "only transactions with this operator id")
+	flags.String("operator", "", "only transactions with this operator name")
+	flags.String("custom-identifier", "", "only the transaction with this custom identifier")
+	flags.String("phone", "", "only transactions to this phone number (filtered after each page is fetched)")
+	flags.String("from", "", "only transactions on or after this date (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
+	flags.String("to", "", "only transactions on or before this date, including all of it if no time is given (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
+}
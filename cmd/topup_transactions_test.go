@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDateAcceptsDatesWithOrWithoutTime(t *testing.T) {
+	d, err := parseDate("2021-12-06")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 6, 0, 0, 0, 0, time.UTC), d)
+
+	d, err = parseDate("2021-12-06 08:00:39")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 6, 8, 0, 39, 0, time.UTC), d)
+
+	_, err = parseDate("06/12/2021")
+	assert.NotNil(t, err)
+}
+
+func TestTopupTransactionsFilterReadsFlags(t *testing.T) {
+	cmd := topupTransactionsCmd
+	cmd.Flags().Set("country", "GB")
+	cmd.Flags().Set("from", "2021-12-01")
+	cmd.Flags().Set("phone", "447951631337")
+	defer func() {
+		cmd.Flags().Set("country", "")
+		cmd.Flags().Set("from", "")
+		cmd.Flags().Set("phone", "")
+	}()
+
+	f, err := topupTransactionsFilter(cmd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), f.Page)
+	assert.Equal(t, "GB", f.CountryCode)
+	assert.Equal(t, "447951631337", f.RecipientPhone)
+	assert.Equal(t, time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), f.StartDate)
+	assert.True(t, f.EndDate.IsZero())
+}
+
+func TestParseEndDateIncludesTheWholeDay(t *testing.T) {
+	d, err := parseEndDate("2021-12-06")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 6, 23, 59, 59, 0, time.UTC), d)
+
+	d, err = parseEndDate("2021-12-06 08:00:39")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 6, 8, 0, 39, 0, time.UTC), d)
+
+	cmd := topupTransactionsCmd
+	cmd.Flags().Set("to", "2021-12-06")
+	defer cmd.Flags().Set("to", "")
+
+	f, err := topupTransactionsFilter(cmd)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 6, 23, 59, 59, 0, time.UTC), f.EndDate)
+}
@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jszwec/csvutil"
+	"github.com/spf13/cobra"
+	"github.com/vlab-research/go-reloadly/reloadly"
+)
+
+// countryCodes are the ISO 3166-1 alpha-2 codes, and XK for Kosovo.
+var countryCodes = strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+	CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO
+	FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE
+	JM JO JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO
+	MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW
+	PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM
+	TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS XK YE YT ZA ZM ZW`)
+
+func isCountryCode(s string) bool {
+	for _, c := range countryCodes {
+		if c == s {
+			return true
+		}
+	}
+	return false
+}
+
+var phoneNumber = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+
+// rawTopupJob is a row of a batch input before it is parsed, so that
+// every problem with it can be reported.
+type rawTopupJob struct {
+	Number           string `csv:"number"`
+	Amount           string `csv:"amount"`
+	Country          string `csv:"country"`
+	Tolerance        string `csv:"tolerance,omitempty"`
+	Operator         string `csv:"operator,omitempty"`
+	ID               string `csv:"id,omitempty"`
+	CustomIdentifier string `csv:"custom_identifier,omitempty"`
+}
+
+// rowIssue is a problem with a row of a batch input. Row 0 is the
+// header. Warnings do not stop the batch.
+type rowIssue struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"`
+}
+
+type validationReport struct {
+	Rows   int        `json:"rows"`
+	Issues []rowIssue `json:"issues"`
+}
+
+func (r *validationReport) add(row int, field, message string, warning bool) {
+	r.Issues = append(r.Issues, rowIssue{Row: row, Field: field, Message: message, Warning: warning})
+}
+
+// errors counts the issues that are not warnings.
+func (r *validationReport) errors() int {
+	n := 0
+	for _, i := range r.Issues {
+		if !i.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *validationReport) print(w io.Writer, output string) error {
+	if output == "json" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tLEVEL\tFIELD\tMESSAGE")
+	for _, i := range r.Issues {
+		level := "error"
+		if i.Warning {
+			level = "warning"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", i.Row, level, i.Field, i.Message)
+	}
+	fmt.Fprintf(tw, "\n%v rows, %v errors, %v warnings\n", r.Rows, r.errors(), len(r.Issues)-r.errors())
+	return tw.Flush()
+}
+
+// validateBatchCsv checks every row of a batch input and reports all
+// the problems it finds, rather than stopping at the first one. If
+// operators is not nil, operator names are looked up too. It only
+// fails if the operators cannot be looked up.
+func validateBatchCsv(ctx context.Context, r io.Reader, operators *operatorCache) (*validationReport, error) {
+	report := &validationReport{Issues: []rowIssue{}}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	dec, err := csvutil.NewDecoder(cr)
+	if err == io.EOF {
+		report.add(0, "", "the file is empty", false)
+		return report, nil
+	}
+	if err != nil {
+		report.add(0, "", err.Error(), false)
+		return report, nil
+	}
+
+	for _, column := range []string{"number", "amount", "country"} {
+		if !contains(dec.Header(), column) {
+			report.add(0, column, "missing column", false)
+		}
+	}
+
+	v := newRowValidator(report, operators)
+	for row := 1; ; row++ {
+		var job rawTopupJob
+		err := dec.Decode(&job)
+		if err == io.EOF {
+			break
+		}
+
+		report.Rows = row
+		if len(dec.Record()) != len(dec.Header()) {
+			report.add(row, "", fmt.Sprintf("has %v fields, the header has %v", len(dec.Record()), len(dec.Header())), false)
+			continue
+		}
+		if err != nil {
+			report.add(row, "", fmt.Sprintf("could not read the rest of the file: %v", err), false)
+			break
+		}
+
+		err = v.validate(ctx, row, job)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if report.Rows == 0 {
+		report.add(0, "", "the file has no rows", false)
+	}
+	return report, nil
+}
+
+// rowValidator checks rows one at a time, adding their issues to
+// report, and remembers numbers, ids and custom identifiers to catch
+// duplicates.
+type rowValidator struct {
+	report    *validationReport
+	operators *operatorCache
+
+	numbers     map[string]int
+	ids         map[string]int
+	identifiers map[string]int
+}
+
+func newRowValidator(report *validationReport, operators *operatorCache) *rowValidator {
+	return &rowValidator{
+		report:      report,
+		operators:   operators,
+		numbers:     map[string]int{},
+		ids:         map[string]int{},
+		identifiers: map[string]int{},
+	}
+}
+
+func (v *rowValidator) validate(ctx context.Context, row int, job rawTopupJob) error {
+	err := validateRow(ctx, v.report, row, job, v.operators)
+	if err != nil {
+		return err
+	}
+
+	if job.Number != "" {
+		if first, ok := v.numbers[job.Number]; ok {
+			v.report.add(row, "number", fmt.Sprintf("same number as row %v", first), true)
+		} else {
+			v.numbers[job.Number] = row
+		}
+	}
+
+	if job.ID != "" {
+		if first, ok := v.ids[job.ID]; ok {
+			v.report.add(row, "id", fmt.Sprintf("same id as row %v", first), false)
+		} else {
+			v.ids[job.ID] = row
+		}
+	}
+
+	if job.CustomIdentifier != "" {
+		if first, ok := v.identifiers[job.CustomIdentifier]; ok {
+			v.report.add(row, "custom_identifier", fmt.Sprintf("same custom identifier as row %v", first), false)
+		} else {
+			v.identifiers[job.CustomIdentifier] = row
+		}
+	}
+	return nil
+}
+
+func validateRow(ctx context.Context, report *validationReport, row int, job rawTopupJob, operators *operatorCache) error {
+	switch {
+	case job.Number == "":
+		report.add(row, "number", "required", false)
+	case !phoneNumber.MatchString(job.Number):
+		report.add(row, "number", fmt.Sprintf("not a phone number (6 to 15 digits, optionally starting with +): %v", job.Number), false)
+	}
+
+	if job.Amount == "" {
+		report.add(row, "amount", "required", false)
+	} else if amount, err := strconv.ParseFloat(job.Amount, 64); err != nil {
+		report.add(row, "amount", fmt.Sprintf("not a number: %v", job.Amount), false)
+	} else if amount <= 0 {
+		report.add(row, "amount", fmt.Sprintf("must be more than 0: %v", job.Amount), false)
+	}
+
+	if job.Tolerance != "" {
+		if tolerance, err := strconv.ParseFloat(job.Tolerance, 64); err != nil {
+			report.add(row, "tolerance", fmt.Sprintf("not a number: %v", job.Tolerance), false)
+		} else if tolerance < 0 {
+			report.add(row, "tolerance", fmt.Sprintf("must not be negative: %v", job.Tolerance), false)
+		}
+	}
+
+	switch {
+	case job.Country == "":
+		report.add(row, "country", "required", false)
+	case !isCountryCode(job.Country):
+		report.add(row, "country", fmt.Sprintf("not an ISO 3166-1 alpha-2 country code: %v", job.Country), false)
+	case operators != nil && job.Operator != "":
+		_, err := operators.find(ctx, job.Country, job.Operator)
+		if errors.Is(err, reloadly.ErrOperatorNotFound) {
+			report.add(row, "operator", fmt.Sprintf("no operator named %v in %v", job.Operator, job.Country), false)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBatchJSONL is like validateBatchCsv, for JSON Lines. Lines
+// that cannot be parsed are reported as a whole.
+func validateBatchJSONL(ctx context.Context, r io.Reader, operators *operatorCache) (*validationReport, error) {
+	report := &validationReport{Issues: []rowIssue{}}
+	v := newRowValidator(report, operators)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	row := 0
+	for scanner.Scan() {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		row++
+		report.Rows = row
+
+		var job reloadly.TopupJob
+		err := json.Unmarshal(b, &job)
+		if err != nil {
+			report.add(row, "", fmt.Sprintf("could not parse: %v", err), false)
+			continue
+		}
+
+		raw := rawTopupJob{
+			Number:           job.Number,
+			Country:          job.Country,
+			Operator:         job.Operator,
+			ID:               job.ID,
+			CustomIdentifier: job.CustomIdentifier,
+		}
+		if job.Amount != 0 {
+			raw.Amount = strconv.FormatFloat(job.Amount, 'f', -1, 64)
+		}
+		if job.Tolerance != 0 {
+			raw.Tolerance = strconv.FormatFloat(job.Tolerance, 'f', -1, 64)
+		}
+
+		err = v.validate(ctx, row, raw)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		report.add(row+1, "", fmt.Sprintf("could not read the rest of the input: %v", err), false)
+	}
+
+	if report.Rows == 0 {
+		report.add(0, "", "the input has no rows", false)
+	}
+	return report, nil
+}
+
+// validateBatchFile validates the batch input at path, or stdin for
+// "-", in the given format, csv or jsonl.
+func validateBatchFile(ctx context.Context, path, format string, operators *operatorCache) (*validationReport, error) {
+	f, err := openBatch(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if format == "jsonl" {
+		return validateBatchJSONL(ctx, bufio.NewReader(f), operators)
+	}
+	return validateBatchCsv(ctx, bufio.NewReader(f), operators)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [input]",
+	Short: "Check every row of a batch input without sending any topups",
+	Long:  "Check every row of a batch input (CSV or JSON Lines, - for stdin) without sending any topups. Operator names are looked up, unless --offline is given.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		offline, err := cmd.Flags().GetBool("offline")
+		if err != nil {
+			return err
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		formatFlag, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+
+		format, err := batchFormat(args[0], formatFlag, "csv")
+		if err != nil {
+			return err
+		}
+
+		var operators *operatorCache
+		if !offline {
+			svc, err := LoadTopupsService(cmd)
+			if err != nil {
+				return err
+			}
+			operators = newOperatorCache(svc.Topups())
+		}
+
+		report, err := validateBatchFile(cmd.Context(), args[0], format, operators)
+		if err != nil {
+			return err
+		}
+
+		err = report.print(cmd.OutOrStdout(), output)
+		if err != nil {
+			return err
+		}
+
+		if n := report.errors(); n > 0 {
+			return fmt.Errorf("%v has %v errors", args[0], n)
+		}
+		return nil
+	},
+}
+
+func init() {
+	topupsCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().Bool("offline", false, "Do not look up operator names with Reloadly")
+	validateCmd.Flags().StringP("output", "o", "table", "Output format: table or json")
+	validateCmd.Flags().String("format", "", "Input format: csv or jsonl (defaults to the file extension, or csv for stdin)")
+}
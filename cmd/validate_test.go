@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBatchCsvReportsEveryRow(t *testing.T) {
+	svc := testTopupsServer(t)
+
+	report, err := validateBatchFile(context.Background(), "test/batch-invalid.csv", "csv", newOperatorCache(svc.Topups()))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, report.Rows)
+
+	issues := []string{}
+	for _, i := range report.Issues {
+		issues = append(issues, fmt.Sprintf("%v:%v", i.Row, i.Field))
+	}
+	assert.Equal(t, []string{
+		"2:number",
+		"2:amount",
+		"3:amount",
+		"3:tolerance",
+		"3:country",
+		"3:number",
+		"3:id",
+		"4:number",
+		"4:operator",
+		"5:",
+	}, issues)
+
+	assert.True(t, report.Issues[5].Warning)
+	assert.Equal(t, 9, report.errors())
+}
+
+func TestValidateBatchCsvOffline(t *testing.T) {
+	report, err := validateBatchFile(context.Background(), "test/batch-invalid.csv", "csv", nil)
+	assert.Nil(t, err)
+	for _, i := range report.Issues {
+		assert.NotEqual(t, "operator", i.Field)
+	}
+}
+
+func TestValidateBatchCsvReportsMissingColumns(t *testing.T) {
+	report, err := validateBatchCsv(context.Background(), strings.NewReader("number,country\n+919876543210,IN\n"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []rowIssue{{Row: 0, Field: "amount", Message: "missing column"}, {Row: 1, Field: "amount", Message: "required"}}, report.Issues)
+
+	report, err = validateBatchCsv(context.Background(), strings.NewReader(""), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.errors())
+}
+
+func TestValidationReportPrintsTable(t *testing.T) {
+	report, _ := validateBatchFile(context.Background(), "test/batch-invalid.csv", "csv", nil)
+
+	var b bytes.Buffer
+	assert.Nil(t, report.print(&b, "table"))
+	assert.Contains(t, b.String(), "3    error    country")
+	assert.Contains(t, b.String(), "6 rows, 8 errors, 1 warnings")
+}
+
+func TestValidateBatchJSONLReportsEveryLine(t *testing.T) {
+	input := "{\"number\": 919876543210, \"amount\": 100, \"country\": \"IN\"}\n" +
+		"{\"number\": \"+919876543211\", \"country\": \"XX\"}\n" +
+		"not json\n"
+
+	report, err := validateBatchJSONL(context.Background(), strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Rows)
+
+	issues := []string{}
+	for _, i := range report.Issues {
+		issues = append(issues, fmt.Sprintf("%v:%v", i.Row, i.Field))
+	}
+	assert.Equal(t, []string{"2:amount", "2:country", "3:"}, issues)
+}
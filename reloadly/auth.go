@@ -1,7 +1,8 @@
 package reloadly
 
 import (
-	"fmt"
+	"context"
+	"time"
 
 	"github.com/dghubble/sling"
 )
@@ -18,32 +19,169 @@ type Token struct {
 	AccessToken string `json:"access_token,omitempty"`
 	Scope       string `json:"scope,omitempty"`
 	ExpiresIn   int64  `json:"expires_in,omitempty"`
+
+	// IssuedAt is set when the token is requested, so that
+	// together with ExpiresIn we know when it goes stale.
+	IssuedAt time.Time `json:"issued_at,omitempty"`
+}
+
+// DefaultTokenRefreshSkew is how long before expiry a token is
+// refreshed when Service.TokenRefreshSkew is not set.
+const DefaultTokenRefreshSkew = 5 * time.Minute
+
+// Expiry returns the time at which the token expires, or the zero
+// time if that is unknown.
+func (t *Token) Expiry() time.Time {
+	if t == nil || t.ExpiresIn <= 0 || t.IssuedAt.IsZero() {
+		return time.Time{}
+	}
+	return t.IssuedAt.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// ExpiresWithin reports whether the token expires within d from
+// now. Tokens with an unknown expiry never report as expiring.
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	expiry := t.Expiry()
+	if expiry.IsZero() {
+		return false
+	}
+	return !time.Now().Add(d).Before(expiry)
+}
+
+// NeedsRefresh reports whether the token should be refreshed, as it
+// expires within skew from now. The skew is capped at half of the
+// token's lifetime, so that a skew longer than the lifetime does not
+// refresh the token on every request.
+func (t *Token) NeedsRefresh(skew time.Duration) bool {
+	if t == nil {
+		return true
+	}
+	if half := time.Duration(t.ExpiresIn) * time.Second / 2; skew > half {
+		skew = half
+	}
+	return t.ExpiresWithin(skew)
 }
 
 func (s *Service) GetOAuthToken(clientId, clientSecret string) (*Token, error) {
+	return s.GetOAuthTokenContext(context.Background(), clientId, clientSecret)
+}
+
+func (s *Service) GetOAuthTokenContext(ctx context.Context, clientId, clientSecret string) (*Token, error) {
 	body := &OAuthRequest{clientId, clientSecret, s.BaseUrl, "client_credentials"}
 	token := new(Token)
 
 	sli := sling.New().Client(s.Client).Base(s.AuthUrl)
 
-	_, err := s.request(sli, "POST", "/oauth/token", body, token)
+	// Take the time before sending, so that any
+	// latency counts against the token lifetime.
+	issuedAt := time.Now()
+	_, err := s.request(ctx, sli, "POST", "/oauth/token", body, token)
+	token.IssuedAt = issuedAt
 	return token, err
 }
 
-func (s *Service) ReAuth() error {
-	if s.id == "" || s.secret == "" {
-		return fmt.Errorf("ReAuth failed as the Service has no stored id and secret")
+// TokenExpiry returns when the current token expires, or the zero
+// time if there is no token or its expiry is unknown.
+func (s *Service) TokenExpiry() time.Time {
+	return s.Token().Expiry()
+}
+
+func (s *Service) tokenRefreshSkew() time.Duration {
+	if s.TokenRefreshSkew > 0 {
+		return s.TokenRefreshSkew
 	}
-	return s.Auth(s.id, s.secret)
+	return DefaultTokenRefreshSkew
 }
 
-func (s *Service) Auth(clientId, clientSecret string) error {
-	res, err := s.GetOAuthToken(clientId, clientSecret)
+// currentToken returns the token to authorize a request with. If
+// there is none yet, or it expires within the refresh skew, a new one
+// is requested from the token source. If the refresh fails but the
+// old token is still valid, the old token is used.
+func (s *Service) currentToken(ctx context.Context) (*Token, error) {
+	token := s.Token()
+	if !s.store().hasSource() {
+		return token, nil
+	}
+
+	if !token.NeedsRefresh(s.tokenRefreshSkew()) {
+		return token, nil
+	}
+
+	fresh, err := s.reAuthFrom(ctx, token)
 	if err != nil {
-		return err
+		if token != nil && !token.ExpiresWithin(0) {
+			return token, nil
+		}
+		return nil, err
 	}
-	s.Token = res
-	s.id = clientId
-	s.secret = clientSecret
-	return nil
+	return fresh, nil
+}
+
+// Token returns the current token, which is shared by every copy
+// of the Service and every sub-service created from it.
+func (s *Service) Token() *Token {
+	return s.store().get()
+}
+
+// SetToken sets the token used to authorize requests, for example
+// one issued elsewhere. Without a TokenSource it is never refreshed.
+func (s *Service) SetToken(token *Token) {
+	s.store().setToken(token)
+}
+
+// SetTokenSource sets where the Service gets its tokens from. The
+// first token is requested lazily, on the next request or ReAuth.
+func (s *Service) SetTokenSource(source TokenSource) {
+	s.store().setSource(source)
+}
+
+// store returns the Service's token store, creating it if needed.
+// Copies of a Service made after Auth (or NewTopups/NewGiftCards)
+// share the same store.
+func (s *Service) store() *tokenStore {
+	return lazyLoad(&s.tokens, func() *tokenStore { return &tokenStore{} })
+}
+
+func (s *Service) ReAuth() error {
+	return s.ReAuthContext(context.Background())
+}
+
+// ReAuthContext requests a new token from the token source.
+func (s *Service) ReAuthContext(ctx context.Context) error {
+	_, err := s.reAuthFrom(ctx, s.Token())
+	return err
+}
+
+// reAuthFrom replaces the stale token with a new one. Concurrent
+// callers holding the same stale token share a single request.
+func (s *Service) reAuthFrom(ctx context.Context, stale *Token) (*Token, error) {
+	return s.store().refresh(ctx, stale, func(token *Token, err error) {
+		s.Metrics.observeTokenRefresh(err)
+		if err != nil {
+			s.logger().WarnContext(ctx, "could not get reloadly token", "error", err)
+			return
+		}
+		s.logger().InfoContext(ctx, "got reloadly token", "expires_at", token.Expiry())
+	})
+}
+
+// Auth authorizes the Service with a client id and secret, which
+// are kept (in a ClientCredentials token source) to refresh the token.
+func (s *Service) Auth(clientId, clientSecret string) error {
+	return s.AuthContext(context.Background(), clientId, clientSecret)
+}
+
+func (s *Service) AuthContext(ctx context.Context, clientId, clientSecret string) error {
+	return s.AuthWithContext(ctx, s.ClientCredentials(clientId, clientSecret))
+}
+
+// AuthWith sets the token source and requests a token from it, so
+// that bad credentials are reported straight away.
+func (s *Service) AuthWith(source TokenSource) error {
+	return s.AuthWithContext(context.Background(), source)
+}
+
+func (s *Service) AuthWithContext(ctx context.Context, source TokenSource) error {
+	s.SetTokenSource(source)
+	return s.ReAuthContext(ctx)
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,7 +31,7 @@ func TestGetAuthTokenMakesAudience(t *testing.T) {
 	err := svc.Auth("id", "secret")
 
 	assert.Nil(t, err)
-	assert.Equal(t, "foobarbaz", svc.Token.AccessToken)
+	assert.Equal(t, "foobarbaz", svc.Token().AccessToken)
 }
 
 func TestReAuthReUsesIdAndSecretAndSetsNewToken(t *testing.T) {
@@ -62,11 +63,11 @@ func TestReAuthReUsesIdAndSecretAndSetsNewToken(t *testing.T) {
 	svc := &Service{AuthUrl: ts.URL, BaseUrl: "reloadly.com", Client: &http.Client{}}
 	err := svc.Auth("id", "secret")
 	assert.Nil(t, err)
-	assert.Equal(t, "foobarbaz", svc.Token.AccessToken)
+	assert.Equal(t, "foobarbaz", svc.Token().AccessToken)
 
 	err = svc.ReAuth()
 	assert.Nil(t, err)
-	assert.Equal(t, "foobarbazqux", svc.Token.AccessToken)
+	assert.Equal(t, "foobarbazqux", svc.Token().AccessToken)
 }
 
 func TestGetAuthTokenReturnsErrors(t *testing.T) {
@@ -87,3 +88,137 @@ func TestGetAuthTokenReturnsErrors(t *testing.T) {
 	assert.Equal(t, e.ErrorCode, "INVALID_CREDENTIALS")
 	assert.Equal(t, e.StatusCode, 401)
 }
+
+func TestAuthRecordsIssueTimeAndExpiry(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "foobarbaz", "expires_in": 3600, "scope": "foo bar baz"}`)
+	})
+
+	before := time.Now()
+	svc := &Service{AuthUrl: ts.URL, BaseUrl: "reloadly.com", Client: &http.Client{}}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	assert.False(t, svc.Token().IssuedAt.Before(before))
+	assert.Equal(t, svc.Token().IssuedAt.Add(time.Hour), svc.TokenExpiry())
+}
+
+func TestTokenExpiryIsZeroWithoutToken(t *testing.T) {
+	svc := &Service{}
+	assert.True(t, svc.TokenExpiry().IsZero())
+}
+
+func TestRequestRefreshesTokenAheadOfExpiry(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	authCount := 0
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 600}`, authCount)
+		authCount++
+	})
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}, TokenRefreshSkew: 15 * time.Minute}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	// With 4 of its 10 minutes left, the token is within the skew,
+	// which is capped at half its lifetime.
+	aged := *svc.Token()
+	aged.IssuedAt = aged.IssuedAt.Add(-6 * time.Minute)
+	svc.SetToken(&aged)
+
+	resp := new(struct{ Bar string })
+	_, err = svc.Request("GET", "/foo", new(struct{}), resp)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, authCount)
+	assert.Equal(t, "qux", resp.Bar)
+}
+
+func TestRefreshSkewIsCappedAtHalfTheTokenLifetime(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	authCount := 0
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 60}`, authCount)
+		authCount++
+	})
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token0", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	// The default skew of 5 minutes is longer than the token lives.
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = svc.Request("GET", "/foo", new(struct{}), new(struct{ Bar string }))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, authCount)
+
+	token := &Token{ExpiresIn: 60, IssuedAt: time.Now().Add(-40 * time.Second)}
+	assert.True(t, token.NeedsRefresh(time.Hour))
+	assert.False(t, token.NeedsRefresh(10*time.Second))
+}
+
+func TestRequestDoesNotRefreshFreshToken(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	authCount := 0
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 86400}`, authCount)
+		authCount++
+	})
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token0", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	resp := new(struct{ Bar string })
+	_, err = svc.Request("GET", "/foo", new(struct{}), resp)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, authCount)
+}
+
+func TestRequestFailsWhenExpiredTokenCannotBeRefreshed(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"message": "Access Denied", "errorCode": "INVALID_CREDENTIALS"}`)
+	})
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not be sent with an expired token")
+	})
+
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}}
+	svc.SetTokenSource(svc.ClientCredentials("id", "secret"))
+	svc.SetToken(&Token{TokenType: "Bearer", AccessToken: "old", ExpiresIn: 60, IssuedAt: time.Now().Add(-time.Hour)})
+
+	resp := new(struct{ Bar string })
+	_, err := svc.Request("GET", "/foo", new(struct{}), resp)
+	assert.NotNil(t, err)
+	assert.Equal(t, "INVALID_CREDENTIALS", err.(APIError).ErrorCode)
+}
@@ -0,0 +1,36 @@
+package reloadly
+
+import (
+	"context"
+)
+
+// Balance is the balance of the Reloadly account for an audience
+// (topups or gift cards).
+type Balance struct {
+	Balance      float64    `json:"balance"`
+	CurrencyCode string     `json:"currencyCode,omitempty"`
+	CurrencyName string     `json:"currencyName,omitempty"`
+	UpdatedAt    *Timestamp `json:"updatedAt,omitempty"`
+}
+
+func (s *Service) balance(ctx context.Context) (*Balance, error) {
+	resp := new(Balance)
+	_, err := s.RequestContext(ctx, "GET", "/accounts/balance", nil, resp)
+	return resp, err
+}
+
+func (s *TopupsService) Balance() (*Balance, error) {
+	return s.BalanceContext(context.Background())
+}
+
+func (s *TopupsService) BalanceContext(ctx context.Context) (*Balance, error) {
+	return s.balance(ctx)
+}
+
+func (s *GiftCardsService) Balance() (*Balance, error) {
+	return s.BalanceContext(context.Background())
+}
+
+func (s *GiftCardsService) BalanceContext(ctx context.Context) (*Balance, error) {
+	return s.balance(ctx)
+}
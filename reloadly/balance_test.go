@@ -0,0 +1,35 @@
+package reloadly
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceGetsAccountBalance(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/balance.json")
+	balance := string(dat)
+
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/accounts/balance", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, balance)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+
+	for _, get := range []func() (*Balance, error){svc.Topups().Balance, svc.GiftCards().Balance} {
+		b, err := get()
+		assert.Nil(t, err)
+		assert.Equal(t, 550.75, b.Balance)
+		assert.Equal(t, "USD", b.CurrencyCode)
+		assert.Equal(t, "US Dollar", b.CurrencyName)
+		assert.Equal(t, time.Date(2018, 12, 4, 8, 45, 51, 0, time.UTC), b.UpdatedAt.Time())
+	}
+}
@@ -2,32 +2,68 @@ package reloadly
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// timestampFormats are the formats Reloadly uses for timestamps in
+// error responses, which differ between its services. Times without
+// a zone are in UTC.
+var timestampFormats = []string{
+	"2006-01-02T15:04:05.000-0700",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// Timestamp is the timeStamp of an error response. It is decoded
+// from any of the formats used by Reloadly, including the JS
+// millisecond timestamps of the identity service. Unknown formats
+// are left as the zero time rather than failing to decode the error,
+// whose RawBody still has the original.
 type Timestamp time.Time
 
 func (t *Timestamp) UnmarshalJSON(b []byte) error {
-	format := "2006-01-02T15:04:05.000-0700"
+	if string(b) == "null" {
+		return nil
+	}
+
+	var js JSTimestamp
+	if err := js.UnmarshalJSON(b); err == nil {
+		*t = Timestamp(js)
+		return nil
+	}
+
 	s := strings.Trim(string(b), "\"")
-	parsed, err := time.Parse(format, s)
-	if err != nil {
-		return err
+	for _, format := range timestampFormats {
+		parsed, err := time.Parse(format, s)
+		if err == nil {
+			*t = Timestamp(parsed)
+			return nil
+		}
 	}
-	*t = Timestamp(parsed)
 	return nil
 }
 
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).Format(time.RFC3339Nano))
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.Time(t)
+}
+
+// JSTimestamp is a JS timestamp in milliseconds, sent either as a
+// number or as a string.
 type JSTimestamp time.Time
 
 func (t *JSTimestamp) UnmarshalJSON(b []byte) error {
-	var s string
-	err := json.Unmarshal(b, &s)
-	if err != nil {
-		return err
+	s := string(b)
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
 	}
 
 	i, err := strconv.ParseInt(s, 10, 64)
@@ -39,16 +75,26 @@ func (t *JSTimestamp) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// TODO: identityerrors return jstimestamp timestamp!!
-
 type APIError struct {
-	ErrorCode string `json:"errorCode,omitempty"`
-	Message   string `json:"message,omitempty"`
-	// TimeStamp *Timestamp `json:"timeStamp,omitempty"`
+	ErrorCode  string              `json:"errorCode,omitempty"`
+	Message    string              `json:"message,omitempty"`
+	TimeStamp  *Timestamp          `json:"timeStamp,omitempty"`
 	InfoLink   string              `json:"infoLink,omitempty"`
 	Path       string              `json:"path,omitempty"`
 	StatusCode int                 `json:"statusCode,omitempty"`
 	Details    []map[string]string `json:"details,omitempty"`
+
+	// RetryAfter is how long Reloadly asked us to wait before
+	// trying again, from the Retry-After header of 429 and 503s.
+	RetryAfter time.Duration `json:"-"`
+
+	// Method, RequestPath and RequestID describe the request that
+	// failed, and RawBody is the response body exactly as Reloadly
+	// sent it, for reporting.
+	Method      string `json:"-"`
+	RequestPath string `json:"-"`
+	RequestID   string `json:"-"`
+	RawBody     string `json:"-"`
 }
 
 func (e APIError) Empty() bool {
@@ -65,6 +111,31 @@ func (e APIError) AddStatus(status int) APIError {
 	return e
 }
 
+// requestIDHeaders are the response headers that may carry an id
+// for the request, in order of preference.
+var requestIDHeaders = []string{
+	"X-Request-Id",
+	"X-Amzn-Requestid",
+	"X-Amz-Cf-Id",
+	"X-Correlation-Id",
+}
+
+// addRequest records the request that failed, and the raw body of
+// the response to it.
+func (e APIError) addRequest(req *http.Request, res *http.Response, body []byte) APIError {
+	e.Method = req.Method
+	e.RequestPath = req.URL.Path
+	e.RawBody = string(body)
+
+	for _, h := range requestIDHeaders {
+		if id := res.Header.Get(h); id != "" {
+			e.RequestID = id
+			break
+		}
+	}
+	return e
+}
+
 func (e APIError) AsError() error {
 	if e.Empty() {
 		return nil
@@ -76,6 +147,15 @@ func (e APIError) Error() string {
 	return fmt.Sprintf("%v: %v", e.ErrorCode, e.Message)
 }
 
+// Is reports whether target has the same error code, so that
+// errors.Is(err, ErrInvalidRecipientPhone) works for API errors.
+func (e APIError) Is(target error) bool {
+	code, ok := targetCode(target)
+	return ok && code == e.ErrorCode
+}
+
+// ReloadlyError is an error detected by this library rather than
+// returned by the Reloadly API.
 type ReloadlyError struct {
 	ErrorCode string
 	Message   string
@@ -84,3 +164,151 @@ type ReloadlyError struct {
 func (e ReloadlyError) Error() string {
 	return fmt.Sprintf("%v: %v", e.ErrorCode, e.Message)
 }
+
+// Is reports whether target has the same error code.
+func (e ReloadlyError) Is(target error) bool {
+	code, ok := targetCode(target)
+	return ok && code == e.ErrorCode
+}
+
+func targetCode(target error) (string, bool) {
+	switch t := target.(type) {
+	case ReloadlyError:
+		return t.ErrorCode, t.ErrorCode != ""
+	case APIError:
+		return t.ErrorCode, t.ErrorCode != ""
+	default:
+		return "", false
+	}
+}
+
+// Sentinels for the error codes of the Reloadly API, and of this
+// library, to be used with errors.Is. Only the ErrorCode is compared.
+var (
+	ErrInsufficientBalance          = ReloadlyError{"INSUFFICIENT_BALANCE", "insufficient balance"}
+	ErrInvalidRecipientPhone        = ReloadlyError{"INVALID_RECIPIENT_PHONE", "invalid recipient phone"}
+	ErrInvalidAmountForOperator     = ReloadlyError{"INVALID_AMOUNT_FOR_OPERATOR", "invalid amount for operator"}
+	ErrTransactionRefusedByOperator = ReloadlyError{"TRANSACTION_REFUSED_BY_OPERATOR", "transaction refused by operator"}
+	ErrPhoneRecentlyRecharged       = ReloadlyError{"PHONE_RECENTLY_RECHARGED", "phone recently recharged"}
+	ErrTransactionCannotBeProcessed = ReloadlyError{"TRANSACTION_CANNOT_BE_PROCESSED_AT_THE_MOMENT", "transaction cannot be processed at the moment"}
+	ErrServiceToOperatorUnavailable = ReloadlyError{"SERVICE_TO_OPERATOR_TEMPORARILY_UNAVAILABLE", "service to operator temporarily unavailable"}
+	ErrProviderInternalError        = ReloadlyError{"PROVIDER_INTERNAL_ERROR", "provider internal error"}
+	ErrCouldNotAutoDetectOperator   = ReloadlyError{"COULD_NOT_AUTO_DETECT_OPERATOR", "could not auto-detect operator"}
+	ErrTokenExpired                 = ReloadlyError{"TOKEN_EXPIRED", "token expired"}
+	ErrTooManyRequests              = ReloadlyError{"429", "too many requests"}
+
+	ErrOperatorNotFound = ReloadlyError{"OPERATOR_NOT_FOUND", "operator not found"}
+	ErrTopupNotFound    = ReloadlyError{"TOPUP_NOT_FOUND", "topup not found"}
+	ErrImpossibleAmount = ReloadlyError{"IMPOSSIBLE_AMOUNT", "impossible amount"}
+	ErrInvalidCall      = ReloadlyError{"INVALID_CALL", "invalid call"}
+)
+
+// noChargeCodes are the error codes for which Reloadly guarantees
+// that nothing was charged, so a topup can safely be sent again.
+var noChargeCodes = map[string]bool{
+	ErrPhoneRecentlyRecharged.ErrorCode:       true,
+	ErrTransactionCannotBeProcessed.ErrorCode: true,
+	ErrServiceToOperatorUnavailable.ErrorCode: true,
+	ErrTooManyRequests.ErrorCode:              true,
+}
+
+// transientCodes are the error codes which may go away by themselves.
+var transientCodes = map[string]bool{
+	ErrPhoneRecentlyRecharged.ErrorCode:       true,
+	ErrTransactionCannotBeProcessed.ErrorCode: true,
+	ErrServiceToOperatorUnavailable.ErrorCode: true,
+	ErrProviderInternalError.ErrorCode:        true,
+	ErrTooManyRequests.ErrorCode:              true,
+	"502":                                     true,
+	"503":                                     true,
+	"504":                                     true,
+}
+
+// TransportError is returned when a request fails without a response
+// from Reloadly, e.g. on a timeout or a dropped connection. It unwraps
+// to the error of the http client.
+type TransportError struct {
+	Err error
+}
+
+func (e TransportError) Error() string {
+	return fmt.Sprintf("TRANSPORT_ERROR: %v", e.Err)
+}
+
+func (e TransportError) Unwrap() error {
+	return e.Err
+}
+
+// CanceledError is returned when a call is abandoned because its
+// context was canceled or its deadline passed. It unwraps to the
+// underlying context error, so errors.Is(err, context.Canceled) works.
+type CanceledError struct {
+	Err error
+
+	// InFlight is set if the request had already been sent, in
+	// which case Reloadly may still have processed it.
+	InFlight bool
+}
+
+func (e CanceledError) Error() string {
+	return fmt.Sprintf("CANCELED: %v", e.Err)
+}
+
+func (e CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is transient, so that the same
+// request may succeed if it is sent again later. Whether it is safe
+// to send again depends on the request, see IsChargeUncertain.
+func IsRetryable(err error) bool {
+	if err == nil || errors.As(err, new(CanceledError)) {
+		return false
+	}
+
+	if errors.As(err, new(TransportError)) {
+		return true
+	}
+
+	var apiError APIError
+	if errors.As(err, &apiError) {
+		return transientCodes[apiError.ErrorCode]
+	}
+	return false
+}
+
+// IsPermanent reports whether Reloadly (or this library) rejected
+// the request, such that sending it again as is will fail again.
+func IsPermanent(err error) bool {
+	if err == nil || IsRetryable(err) || IsChargeUncertain(err) {
+		return false
+	}
+
+	return errors.As(err, new(APIError)) || errors.As(err, new(ReloadlyError))
+}
+
+// IsChargeUncertain reports whether a request that charges (such as
+// a topup) may have gone through despite err: the connection failed
+// or was canceled after sending, or Reloadly failed with a server
+// error without guaranteeing that nothing was charged. Such requests
+// should be checked before they are sent again.
+func IsChargeUncertain(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var canceled CanceledError
+	if errors.As(err, &canceled) {
+		return canceled.InFlight
+	}
+
+	if errors.As(err, new(TransportError)) {
+		return true
+	}
+
+	var apiError APIError
+	if errors.As(err, &apiError) {
+		return apiError.StatusCode >= 500 && !noChargeCodes[apiError.ErrorCode]
+	}
+	return false
+}
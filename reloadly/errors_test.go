@@ -0,0 +1,186 @@
+package reloadly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIErrorIsSentinelWithSameCode(t *testing.T) {
+	err := fmt.Errorf("topup: %w", APIError{ErrorCode: "INVALID_RECIPIENT_PHONE", Message: "bad phone", StatusCode: 400})
+
+	assert.True(t, errors.Is(err, ErrInvalidRecipientPhone))
+	assert.False(t, errors.Is(err, ErrInsufficientBalance))
+
+	var apiError APIError
+	assert.True(t, errors.As(err, &apiError))
+	assert.Equal(t, 400, apiError.StatusCode)
+}
+
+func TestReloadlyErrorIsSentinelWithSameCode(t *testing.T) {
+	err := ReloadlyError{"OPERATOR_NOT_FOUND", "Could not find operator with name: Foo in country: IN"}
+
+	assert.True(t, errors.Is(err, ErrOperatorNotFound))
+	assert.False(t, errors.Is(err, ErrImpossibleAmount))
+	assert.False(t, errors.Is(err, ReloadlyError{}))
+}
+
+func TestErrorClassifiers(t *testing.T) {
+	transport := TransportError{&net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+
+	cases := []struct {
+		err       error
+		retryable bool
+		permanent bool
+		uncertain bool
+	}{
+		{nil, false, false, false},
+		{APIError{ErrorCode: "INSUFFICIENT_BALANCE", StatusCode: 400}, false, true, false},
+		{APIError{ErrorCode: "INVALID_RECIPIENT_PHONE", StatusCode: 400}, false, true, false},
+		{APIError{ErrorCode: "PHONE_RECENTLY_RECHARGED", StatusCode: 400}, true, false, false},
+		{APIError{ErrorCode: "TRANSACTION_CANNOT_BE_PROCESSED_AT_THE_MOMENT", StatusCode: 500}, true, false, false},
+		{APIError{ErrorCode: "PROVIDER_INTERNAL_ERROR", StatusCode: 500}, true, false, true},
+		{APIError{ErrorCode: "503", StatusCode: 503}, true, false, true},
+		{APIError{ErrorCode: "429", StatusCode: 429}, true, false, false},
+		{ReloadlyError{"IMPOSSIBLE_AMOUNT", "no"}, false, true, false},
+		{transport, true, false, true},
+		{CanceledError{Err: context.Canceled}, false, false, false},
+		{CanceledError{Err: context.Canceled, InFlight: true}, false, false, true},
+		{errors.New("unexpected end of JSON input"), false, false, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.retryable, IsRetryable(c.err), "IsRetryable(%v)", c.err)
+		assert.Equal(t, c.permanent, IsPermanent(c.err), "IsPermanent(%v)", c.err)
+		assert.Equal(t, c.uncertain, IsChargeUncertain(c.err), "IsChargeUncertain(%v)", c.err)
+	}
+}
+
+func TestRequestWrapsTransportErrors(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {})
+	url := ts.URL
+	ts.Close()
+
+	svc := &Service{BaseUrl: url, Client: &http.Client{}}
+	_, err := svc.Request("GET", "/foo", nil, new(struct{}))
+
+	var transport TransportError
+	assert.True(t, errors.As(err, &transport))
+
+	var opError *net.OpError
+	assert.True(t, errors.As(err, &opError))
+	assert.True(t, IsChargeUncertain(err))
+}
+
+func TestAPIErrorDecodesTimestampsInAnyFormat(t *testing.T) {
+	cases := map[string]time.Time{
+		`"2020-09-18T08:26:27.577+0000"`: time.Date(2020, 9, 18, 8, 26, 27, 577000000, time.UTC),
+		`"2021-11-16 20:28:00"`:          time.Date(2021, 11, 16, 20, 28, 0, 0, time.UTC),
+		`"1600417587577"`:                time.Date(2020, 9, 18, 8, 26, 27, 577000000, time.UTC),
+		`1600417587577`:                  time.Date(2020, 9, 18, 8, 26, 27, 577000000, time.UTC),
+		`"2020-09-18T08:26:27Z"`:         time.Date(2020, 9, 18, 8, 26, 27, 0, time.UTC),
+	}
+
+	for ts, expected := range cases {
+		var e APIError
+		err := json.Unmarshal([]byte(`{"timeStamp": `+ts+`, "errorCode": "FOO"}`), &e)
+		assert.Nil(t, err)
+		assert.True(t, expected.Equal(e.TimeStamp.Time()), "timestamp %v", ts)
+	}
+}
+
+func TestAPIErrorDecodesUnknownTimestampsAsZero(t *testing.T) {
+	var e APIError
+	err := json.Unmarshal([]byte(`{"timeStamp": "yesterday", "errorCode": "FOO"}`), &e)
+	assert.Nil(t, err)
+	assert.Equal(t, "FOO", e.ErrorCode)
+	assert.True(t, e.TimeStamp.Time().IsZero())
+
+	err = json.Unmarshal([]byte(`{"timeStamp": null, "errorCode": "FOO"}`), &e)
+	assert.Nil(t, err)
+}
+
+func TestAPIErrorKeepsRequestAndRawBody(t *testing.T) {
+	body := `{"timeStamp":"2021-11-16 07:31:37","message":"Insufficient funds in the wallet to complete this transaction","path":"/orders","errorCode":"INSUFFICIENT_BALANCE","infoLink":null,"details":[]}`
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amzn-Requestid", "abc-123")
+		w.WriteHeader(400)
+		fmt.Fprint(w, body)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	_, err := svc.Request("POST", "/orders", struct{}{}, new(struct{}))
+
+	var e APIError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, "/orders", e.RequestPath)
+	assert.Equal(t, "abc-123", e.RequestID)
+	assert.Equal(t, body, e.RawBody)
+	assert.Equal(t, time.Date(2021, 11, 16, 7, 31, 37, 0, time.UTC), e.TimeStamp.Time())
+}
+
+func TestAPIErrorFromEmptyBodyKeepsRequest(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "xyz")
+		w.WriteHeader(502)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	_, err := svc.Request("GET", "/foo", nil, new(struct{}))
+
+	var e APIError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "502", e.ErrorCode)
+	assert.Equal(t, "GET", e.Method)
+	assert.Equal(t, "xyz", e.RequestID)
+	assert.Equal(t, "", e.RawBody)
+}
+
+func TestAPIErrorFromHTMLBodyKeepsStatus(t *testing.T) {
+	page := "<html><body><h1>502 Bad Gateway</h1></body></html>"
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(502)
+		fmt.Fprint(w, page)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	_, err := svc.Request("GET", "/foo", nil, new(struct{}))
+
+	var e APIError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "502", e.ErrorCode)
+	assert.Equal(t, 502, e.StatusCode)
+	assert.Equal(t, page, e.RawBody)
+	assert.Equal(t, "502", errorCodeOf(err))
+	assert.True(t, IsRetryable(err))
+	assert.False(t, IsPermanent(err))
+}
+
+func TestHTMLGatewayErrorOnTopupIsChargeUncertain(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(504)
+		fmt.Fprint(w, "<html><body>Gateway Timeout</body></html>")
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	_, err := svc.Topups().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+
+	assert.True(t, IsChargeUncertain(err))
+	assert.Equal(t, "504", errorCodeOf(err))
+
+	var e APIError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, "/topups", e.RequestPath)
+}
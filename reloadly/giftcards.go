@@ -1,6 +1,7 @@
 package reloadly
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -116,14 +117,13 @@ type GiftCardsService struct {
 
 func NewGiftCards() *Service {
 	return &Service{
-		http.DefaultClient,
-		"https://giftcards.reloadly.com",
-		"https://auth.reloadly.com",
-		nil,
-		"",
-		"",
-		"https://giftcards-sandbox.reloadly.com",
-		"application/com.reloadly.giftcards-v1+json",
+		Client:       http.DefaultClient,
+		BaseUrl:      "https://giftcards.reloadly.com",
+		AuthUrl:      "https://auth.reloadly.com",
+		tokens:       &tokenStore{},
+		limits:       newRateLimits(),
+		sandboxUrl:   "https://giftcards-sandbox.reloadly.com",
+		acceptHeader: "application/com.reloadly.giftcards-v1+json",
 	}
 }
 
@@ -132,79 +132,123 @@ func (s *Service) GiftCards() *GiftCardsService {
 }
 
 func (s *GiftCardsService) Products(page int64, size int64) (ProductsPage, error) {
+	return s.ProductsContext(context.Background(), page, size)
+}
+
+func (s *GiftCardsService) ProductsContext(ctx context.Context, page int64, size int64) (ProductsPage, error) {
 	path := fmt.Sprintf("/products?page=%v&size=%v", page, size)
 	resp := new(ProductsPage)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	resp.Page = page
 	return *resp, err
 }
 
 func (s *GiftCardsService) Product(productId int64) (Product, error) {
+	return s.ProductContext(context.Background(), productId)
+}
+
+func (s *GiftCardsService) ProductContext(ctx context.Context, productId int64) (Product, error) {
 	path := fmt.Sprintf("/products/%v", productId)
 	resp := new(Product)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) ProductsByCountry(country string) ([]Product, error) {
+	return s.ProductsByCountryContext(context.Background(), country)
+}
+
+func (s *GiftCardsService) ProductsByCountryContext(ctx context.Context, country string) ([]Product, error) {
 	path := fmt.Sprintf("/countries/%v/products", country)
 	resp := new([]Product)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) RedeemInstructions() ([]RedeemInstructions, error) {
+	return s.RedeemInstructionsContext(context.Background())
+}
+
+func (s *GiftCardsService) RedeemInstructionsContext(ctx context.Context) ([]RedeemInstructions, error) {
 	resp := new([]RedeemInstructions)
-	_, err := s.Request("GET", "/redeem-instructions", nil, resp)
+	_, err := s.RequestContext(ctx, "GET", "/redeem-instructions", nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) RedeemInstructionsByBrand(brandId int64) (RedeemInstructions, error) {
+	return s.RedeemInstructionsByBrandContext(context.Background(), brandId)
+}
+
+func (s *GiftCardsService) RedeemInstructionsByBrandContext(ctx context.Context, brandId int64) (RedeemInstructions, error) {
 	path := fmt.Sprintf("/redeem-instructions/%v", brandId)
 	resp := new(RedeemInstructions)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) Discounts(page int64, size int64) (DiscountsPage, error) {
+	return s.DiscountsContext(context.Background(), page, size)
+}
+
+func (s *GiftCardsService) DiscountsContext(ctx context.Context, page int64, size int64) (DiscountsPage, error) {
 	path := fmt.Sprintf("/discounts?page=%v&size=%v", page, size)
 	resp := new(DiscountsPage)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	resp.Page = page
 	return *resp, err
 }
 
 func (s *GiftCardsService) DiscountByProduct(productId int64) (Discount, error) {
+	return s.DiscountByProductContext(context.Background(), productId)
+}
+
+func (s *GiftCardsService) DiscountByProductContext(ctx context.Context, productId int64) (Discount, error) {
 	path := fmt.Sprintf("/products/%v/discounts", productId)
 	resp := new(Discount)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) Transactions(page int64, size int64) (TransactionsPage, error) {
+	return s.TransactionsContext(context.Background(), page, size)
+}
+
+func (s *GiftCardsService) TransactionsContext(ctx context.Context, page int64, size int64) (TransactionsPage, error) {
 	path := fmt.Sprintf("/reports/transactions?page=%v&size=%v", page, size)
 	resp := new(TransactionsPage)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	resp.Page = page
 	return *resp, err
 }
 
 func (s *GiftCardsService) Transaction(transactionId int64) (Transaction, error) {
+	return s.TransactionContext(context.Background(), transactionId)
+}
+
+func (s *GiftCardsService) TransactionContext(ctx context.Context, transactionId int64) (Transaction, error) {
 	path := fmt.Sprintf("/reports/transactions/%v", transactionId)
 	resp := new(Transaction)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) Order(order GiftCardOrder) (Transaction, error) {
+	return s.OrderContext(context.Background(), order)
+}
+
+func (s *GiftCardsService) OrderContext(ctx context.Context, order GiftCardOrder) (Transaction, error) {
 	resp := new(Transaction)
-	_, err := s.Request("POST", "/orders", order, resp)
+	_, err := s.RequestContext(ctx, "POST", "/orders", order, resp)
 	return *resp, err
 }
 
 func (s *GiftCardsService) GetRedeemCode(transactionId int64) ([]Card, error) {
+	return s.GetRedeemCodeContext(context.Background(), transactionId)
+}
+
+func (s *GiftCardsService) GetRedeemCodeContext(ctx context.Context, transactionId int64) ([]Card, error) {
 	path := fmt.Sprintf("/orders/transactions/%v/cards", transactionId)
 	resp := new([]Card)
-	_, err := s.Request("GET", path, nil, resp)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
 	return *resp, err
 }
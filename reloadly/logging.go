@@ -0,0 +1,56 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// discardHandler drops every record, for Services without a Logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+var discardLogger = slog.New(discardHandler{})
+
+// logger returns the Service's Logger, or one that discards
+// everything if it has none.
+func (s *Service) logger() *slog.Logger {
+	if s.Logger == nil {
+		return discardLogger
+	}
+	return s.Logger
+}
+
+// logPath hides phone numbers in paths like
+// /operators/auto-detect/phone/{phone}/countries/{country}, so that
+// they are never logged.
+func logPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == "phone" && parts[i] != "" {
+			parts[i] = "REDACTED"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// logError returns the message of err with phone numbers hidden in
+// the url of transport errors, which net/http includes in full.
+func logError(err error) string {
+	msg := err.Error()
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, perr := url.Parse(urlErr.URL); perr == nil {
+			redacted := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: logPath(u.Path)}
+			msg = strings.ReplaceAll(msg, urlErr.URL, redacted.String())
+		}
+	}
+	return msg
+}
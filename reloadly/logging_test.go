@@ -0,0 +1,88 @@
+package reloadly
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestServiceWithoutLoggerIsSilent(t *testing.T) {
+	svc := &Service{}
+	assert.False(t, svc.logger().Enabled(context.Background(), slog.LevelError))
+}
+
+func TestServiceLogsRequestsAndRetries(t *testing.T) {
+	calls := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	buf := new(bytes.Buffer)
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, RetryPolicy: testRetryPolicy(), Logger: testLogger(buf)}
+
+	_, err := svc.Request("GET", "/foo", nil, new(struct{ Bar string }))
+	assert.Nil(t, err)
+
+	logs := buf.String()
+	assert.Contains(t, logs, `level=WARN msg="retrying reloadly request" method=GET path=/foo attempt=1`)
+	assert.Contains(t, logs, `level=DEBUG msg="reloadly request" method=GET path=/foo status=200`)
+}
+
+func TestServiceLogsTokenRefresh(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "secret", "token_type": "Bearer", "expires_in": 3600}`)
+	})
+
+	buf := new(bytes.Buffer)
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}, Logger: testLogger(buf)}
+
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	logs := buf.String()
+	assert.Contains(t, logs, `level=INFO msg="got reloadly token"`)
+	assert.NotContains(t, logs, "secret")
+}
+
+func TestTopupLogsAutoDetectedOperatorWithoutPhone(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/airtel.json")
+	airtel := string(dat)
+	ts, mux := TestServerMux()
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"hey": "yeah"}`)
+	})
+
+	buf := new(bytes.Buffer)
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, Logger: testLogger(buf)}
+	_, err := svc.Topups().AutoDetect("IN").Topup("+123", 100)
+	assert.Nil(t, err)
+
+	logs := buf.String()
+	assert.Contains(t, logs, `level=INFO msg="auto-detected operator" country=IN operator_id=200`)
+	assert.NotContains(t, logs, "+123")
+	assert.Contains(t, logs, "path=/operators/auto-detect/phone/REDACTED/countries/IN")
+}
@@ -0,0 +1,232 @@
+package reloadly
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts what a Service does: requests, topups, fallbacks,
+// auto-detection, token refreshes and batch jobs. It is a
+// prometheus.Collector, so register it with any registry:
+//
+//	svc.Metrics = reloadly.NewMetrics()
+//	prometheus.MustRegister(svc.Metrics)
+//
+// Labels never include phone numbers or ids, only templated paths.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	topups          *prometheus.CounterVec
+	topupAmount     *prometheus.CounterVec
+	autoDetects     *prometheus.CounterVec
+	fallbacks       *prometheus.CounterVec
+	tokenRefreshes  *prometheus.CounterVec
+	jobs            *prometheus.CounterVec
+	jobDuration     *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "requests_total",
+			Help:      "Requests sent to Reloadly, by endpoint, status and error code.",
+		}, []string{"method", "endpoint", "status", "error_code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "reloadly",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests sent to Reloadly.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "endpoint"}),
+		topups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "topups_total",
+			Help:      "Topups attempted, by country, operator and error code.",
+		}, []string{"country", "operator", "error_code"}),
+		topupAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "topup_amount_total",
+			Help:      "Amount requested in successful topups, by country, operator and currency.",
+		}, []string{"country", "operator", "currency"}),
+		autoDetects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "operator_auto_detects_total",
+			Help:      "Operator auto-detections, by country and error code.",
+		}, []string{"country", "error_code"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "topup_fallbacks_total",
+			Help:      "Topups retried with auto-detection after failing with the given operator.",
+		}, []string{"country", "operator", "error_code"}),
+		tokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "token_refreshes_total",
+			Help:      "Access tokens requested from the token source, by result.",
+		}, []string{"result"}),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "reloadly",
+			Name:      "topup_jobs_total",
+			Help:      "Batch topup jobs done by TopupWorker, by country and error code.",
+		}, []string{"country", "error_code"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "reloadly",
+			Name:      "topup_job_duration_seconds",
+			Help:      "Time taken by batch topup jobs, including lookups, retries and fallbacks.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"country"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests,
+		m.requestDuration,
+		m.topups,
+		m.topupAmount,
+		m.autoDetects,
+		m.fallbacks,
+		m.tokenRefreshes,
+		m.jobs,
+		m.jobDuration,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// errorCode returns the Reloadly error code of err, if any, for use
+// as a label. Other errors are all labelled "ERROR".
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if code := errorCodeOf(err); code != "" {
+		return code
+	}
+	return "ERROR"
+}
+
+// errorCodeOf returns the Reloadly error code of err, CANCELED or
+// TRANSPORT_ERROR, or "" for other errors.
+func errorCodeOf(err error) string {
+	var apiError APIError
+	if errors.As(err, &apiError) {
+		return apiError.ErrorCode
+	}
+
+	var reloadlyError ReloadlyError
+	if errors.As(err, &reloadlyError) {
+		return reloadlyError.ErrorCode
+	}
+
+	if errors.As(err, new(CanceledError)) {
+		return "CANCELED"
+	}
+
+	if errors.As(err, new(TransportError)) {
+		return "TRANSPORT_ERROR"
+	}
+	return ""
+}
+
+// metricsEndpoint turns a path into a template, so that phone numbers,
+// countries and ids do not end up as label values.
+func metricsEndpoint(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		switch {
+		case parts[i-1] == "phone" && parts[i] != "":
+			parts[i] = "{phone}"
+		case parts[i-1] == "countries" && parts[i] != "":
+			parts[i] = "{country}"
+		case isNumeric(parts[i]):
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Metrics) observeRequest(method, path string, status int, err error, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	endpoint := metricsEndpoint(path)
+	statusLabel := "none"
+	if status != 0 {
+		statusLabel = fmt.Sprint(status)
+	}
+
+	m.requests.WithLabelValues(method, endpoint, statusLabel, errorCode(err)).Inc()
+	m.requestDuration.WithLabelValues(method, endpoint).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) observeTopup(country, operator string, res *TopupResponse, err error) {
+	if m == nil {
+		return
+	}
+
+	m.topups.WithLabelValues(country, operator, errorCode(err)).Inc()
+	if err == nil && res != nil {
+		m.topupAmount.WithLabelValues(country, operator, res.RequestedAmountCurrencyCode).Add(res.RequestedAmount)
+	}
+}
+
+func (m *Metrics) observeAutoDetect(country string, err error) {
+	if m == nil {
+		return
+	}
+	m.autoDetects.WithLabelValues(country, errorCode(err)).Inc()
+}
+
+func (m *Metrics) observeFallback(country, operator string, err error) {
+	if m == nil {
+		return
+	}
+	m.fallbacks.WithLabelValues(country, operator, errorCode(err)).Inc()
+}
+
+func (m *Metrics) observeTokenRefresh(err error) {
+	if m == nil {
+		return
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.tokenRefreshes.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) observeJob(country string, err error, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.jobs.WithLabelValues(country, errorCode(err)).Inc()
+	m.jobDuration.WithLabelValues(country).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,87 @@
+package reloadly
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpointHidesPhonesCountriesAndIds(t *testing.T) {
+	assert.Equal(t, "/operators/auto-detect/phone/{phone}/countries/{country}", metricsEndpoint("/operators/auto-detect/phone/+123/countries/IN"))
+	assert.Equal(t, "/operators/countries/{country}", metricsEndpoint("/operators/countries/IN"))
+	assert.Equal(t, "/operators/{id}", metricsEndpoint("/operators/200"))
+	assert.Equal(t, "/topups", metricsEndpoint("/topups"))
+}
+
+func TestMetricsCanBeRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(NewMetrics()))
+}
+
+func TestNilMetricsAreIgnored(t *testing.T) {
+	var m *Metrics
+	m.observeRequest("GET", "/foo", 200, nil, 0)
+	m.observeTopup("IN", "Airtel", nil, nil)
+	m.observeJob("IN", nil, 0)
+}
+
+func TestMetricsCountRequestsTopupsAndFallbacks(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/airtel.json")
+	airtel := string(dat)
+	ts, mux := TestServerMux()
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	count := 0
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if count == 0 {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, `{"errorCode": "INVALID_RECIPIENT_PHONE"}`)
+		} else {
+			fmt.Fprintf(w, `{"requestedAmount": 100, "requestedAmountCurrencyCode": "INR"}`)
+		}
+		count++
+	})
+
+	metrics := NewMetrics()
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, Metrics: metrics}
+	op := Operator{Name: "Foodafone", OperatorID: 1, Country: Country{"IN", "India"}}
+	_, err := svc.Topups().Operator(&op).AutoFallback().Topup("+123", 100)
+	assert.Nil(t, err)
+
+	worker := TopupWorker(*svc)
+	res := worker.Do(&TopupJob{Number: "+123", Amount: 100, Country: "IN"})
+	assert.Equal(t, "", res.ErrorCode)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.requests.WithLabelValues("POST", "/topups", "404", "INVALID_RECIPIENT_PHONE")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.requests.WithLabelValues("GET", "/operators/auto-detect/phone/{phone}/countries/{country}", "200", "")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.topups.WithLabelValues("IN", "Foodafone", "INVALID_RECIPIENT_PHONE")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.fallbacks.WithLabelValues("IN", "Foodafone", "INVALID_RECIPIENT_PHONE")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.autoDetects.WithLabelValues("IN", "")))
+	assert.Equal(t, float64(200), testutil.ToFloat64(metrics.topupAmount.WithLabelValues("IN", "Airtel India", "INR")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.jobs.WithLabelValues("IN", "")))
+}
+
+func TestMetricsCountTokenRefreshes(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"errorCode": "INVALID_CREDENTIALS"}`)
+	})
+
+	metrics := NewMetrics()
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}, Metrics: metrics}
+	err := svc.Auth("id", "secret")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.tokenRefreshes.WithLabelValues("error")))
+}
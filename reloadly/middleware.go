@@ -0,0 +1,128 @@
+package reloadly
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Middleware observes or modifies the traffic of a Service. It sees
+// every http request the Service makes, including auth and re-auth.
+// Any of the hooks may be nil.
+type Middleware struct {
+	// BeforeRequest is called just before a request is sent, and
+	// may modify it. Returning an error aborts the request.
+	BeforeRequest func(req *http.Request) error
+
+	// AfterResponse is called with every response, whatever its
+	// status, and how long it took.
+	AfterResponse func(req *http.Request, res *http.Response, elapsed time.Duration)
+
+	// OnError is called when a request fails, either without a
+	// response or with an APIError.
+	OnError func(req *http.Request, err error, elapsed time.Duration)
+}
+
+// Use adds middlewares to the Service. BeforeRequest hooks run in the
+// order they were added, AfterResponse and OnError in reverse.
+func (s *Service) Use(middlewares ...Middleware) {
+	s.Middlewares = append(s.Middlewares, middlewares...)
+}
+
+func (s *Service) beforeRequest(req *http.Request) error {
+	for _, m := range s.Middlewares {
+		if m.BeforeRequest == nil {
+			continue
+		}
+		if err := m.BeforeRequest(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) afterResponse(req *http.Request, res *http.Response, elapsed time.Duration) {
+	for i := len(s.Middlewares) - 1; i >= 0; i-- {
+		if m := s.Middlewares[i]; m.AfterResponse != nil {
+			m.AfterResponse(req, res, elapsed)
+		}
+	}
+}
+
+func (s *Service) onError(req *http.Request, err error, elapsed time.Duration) {
+	for i := len(s.Middlewares) - 1; i >= 0; i-- {
+		if m := s.Middlewares[i]; m.OnError != nil {
+			m.OnError(req, err, elapsed)
+		}
+	}
+}
+
+// LogFunc logs a message with alternating keys and values, like
+// the methods of log/slog.Logger.
+type LogFunc func(msg string, keyvals ...interface{})
+
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// RedactHeaders returns a copy of header with the values of headers
+// that carry secrets replaced.
+func RedactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+// LoggingMiddleware logs every request and its outcome. Bodies are
+// never logged, as auth requests carry the client secret, credentials
+// in headers are redacted, and phone numbers are hidden in paths.
+func LoggingMiddleware(log LogFunc) Middleware {
+	return Middleware{
+		AfterResponse: func(req *http.Request, res *http.Response, elapsed time.Duration) {
+			log("reloadly request",
+				"method", req.Method,
+				"path", logPath(req.URL.Path),
+				"status", res.StatusCode,
+				"elapsed", elapsed,
+				"headers", RedactHeaders(req.Header))
+		},
+		OnError: func(req *http.Request, err error, elapsed time.Duration) {
+			log("reloadly request failed",
+				"method", req.Method,
+				"path", logPath(req.URL.Path),
+				"error", logError(err),
+				"elapsed", elapsed)
+		},
+	}
+}
+
+// HeaderMiddleware sets the given headers on every request.
+func HeaderMiddleware(header http.Header) Middleware {
+	return Middleware{
+		BeforeRequest: func(req *http.Request) error {
+			for name, values := range header {
+				req.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+			return nil
+		},
+	}
+}
+
+// LatencyMiddleware calls observe with the latency of every request.
+// Requests which failed without a response have status 0.
+func LatencyMiddleware(observe func(method, path string, status int, elapsed time.Duration)) Middleware {
+	return Middleware{
+		AfterResponse: func(req *http.Request, res *http.Response, elapsed time.Duration) {
+			observe(strings.ToUpper(req.Method), req.URL.Path, res.StatusCode, elapsed)
+		},
+		OnError: func(req *http.Request, err error, elapsed time.Duration) {
+			if errors.As(err, new(APIError)) {
+				return
+			}
+			observe(strings.ToUpper(req.Method), req.URL.Path, 0, elapsed)
+		},
+	}
+}
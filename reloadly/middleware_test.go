@@ -0,0 +1,155 @@
+package reloadly
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareHooksRunInOrder(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "a,b", r.Header.Get("X-Order"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	calls := []string{}
+	hook := func(name string) Middleware {
+		return Middleware{
+			BeforeRequest: func(req *http.Request) error {
+				if prev := req.Header.Get("X-Order"); prev != "" {
+					name = prev + "," + name
+				}
+				req.Header.Set("X-Order", name)
+				calls = append(calls, "before "+name)
+				return nil
+			},
+			AfterResponse: func(req *http.Request, res *http.Response, elapsed time.Duration) {
+				calls = append(calls, "after "+name)
+			},
+		}
+	}
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.Use(hook("a"), hook("b"))
+
+	_, err := svc.Request("GET", "/foo", nil, new(struct{ Bar string }))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"before a", "before a,b", "after a,b", "after a"}, calls)
+}
+
+func TestMiddlewareBeforeRequestErrorAbortsRequest(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not be sent")
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.Use(Middleware{BeforeRequest: func(req *http.Request) error {
+		return fmt.Errorf("nope")
+	}})
+
+	_, err := svc.Request("GET", "/foo", nil, nil)
+	assert.Equal(t, "nope", err.Error())
+}
+
+func TestMiddlewareSeesAuthRequestsAndErrors(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"message": "Access Denied", "errorCode": "INVALID_CREDENTIALS"}`)
+	})
+
+	paths := []string{}
+	errs := []error{}
+	svc := &Service{AuthUrl: ts.URL, BaseUrl: "reloadly.com", Client: &http.Client{}}
+	svc.Use(Middleware{
+		AfterResponse: func(req *http.Request, res *http.Response, elapsed time.Duration) {
+			paths = append(paths, req.URL.Path)
+		},
+		OnError: func(req *http.Request, err error, elapsed time.Duration) {
+			errs = append(errs, err)
+		},
+	})
+
+	err := svc.Auth("id", "secret")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"/oauth/token"}, paths)
+	assert.Equal(t, "INVALID_CREDENTIALS", errs[0].(APIError).ErrorCode)
+}
+
+func TestLoggingMiddlewareRedactsAuthorization(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	logged := map[string]interface{}{}
+	log := func(msg string, keyvals ...interface{}) {
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			logged[keyvals[i].(string)] = keyvals[i+1]
+		}
+	}
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetToken(&Token{TokenType: "Bearer", AccessToken: "supersecret"})
+	svc.Use(LoggingMiddleware(log))
+
+	_, err := svc.Request("GET", "/foo", nil, new(struct{ Bar string }))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "GET", logged["method"])
+	assert.Equal(t, 200, logged["status"])
+	assert.Equal(t, "REDACTED", logged["headers"].(http.Header).Get("Authorization"))
+	assert.NotContains(t, fmt.Sprint(logged), "supersecret")
+}
+
+func TestHeaderAndLatencyMiddlewares(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bar", r.Header.Get("X-Foo"))
+		w.WriteHeader(204)
+	})
+
+	observed := []string{}
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.Use(
+		HeaderMiddleware(http.Header{"X-Foo": []string{"bar"}}),
+		LatencyMiddleware(func(method, path string, status int, elapsed time.Duration) {
+			observed = append(observed, fmt.Sprintf("%v %v %v", method, path, status))
+		}),
+	)
+
+	_, err := svc.Request("DELETE", "/foo", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"DELETE /foo 204"}, observed)
+}
+
+func TestLoggingMiddlewareHidesPhoneNumbers(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	lines := []string{}
+	log := func(msg string, keyvals ...interface{}) {
+		lines = append(lines, fmt.Sprint(msg, keyvals))
+	}
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.Use(LoggingMiddleware(log))
+
+	_, err := svc.Request("GET", "/operators/auto-detect/phone/+447951631337/countries/GB", nil, new(struct{ Bar string }))
+	assert.Nil(t, err)
+
+	ts.Close()
+	_, err = svc.Request("GET", "/operators/auto-detect/phone/+447951631337/countries/GB", nil, new(struct{ Bar string }))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		assert.Contains(t, line, "/phone/REDACTED/countries/GB")
+		assert.NotContains(t, line, "447951631337")
+	}
+}
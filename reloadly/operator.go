@@ -1,9 +1,12 @@
 package reloadly
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Country struct {
@@ -190,23 +193,55 @@ type OperatorsParams struct {
 }
 
 func (s *TopupsService) OperatorsAutoDetect(mobile, country string) (*Operator, error) {
+	return s.OperatorsAutoDetectContext(context.Background(), mobile, country)
+}
+
+func (s *TopupsService) OperatorsAutoDetectContext(ctx context.Context, mobile, country string) (*Operator, error) {
+	ctx, span := s.startSpan(ctx, "OperatorsAutoDetect", attribute.String("reloadly.country", country))
+
 	path := fmt.Sprintf("/operators/auto-detect/phone/%v/countries/%v", mobile, country)
 	params := &OperatorsParams{SuggestedAmountsMap: true, SuggestedAmounts: true}
 	resp := new(Operator)
-	_, err := s.Request("GET", path, params, resp)
+	_, err := s.RequestContext(ctx, "GET", path, params, resp)
+
+	if err == nil {
+		span.SetAttributes(operatorAttributes(resp)...)
+	}
+	endSpan(span, err)
 	return resp, err
 }
 
 func (s *TopupsService) OperatorsByCountry(country string) ([]Operator, error) {
+	return s.OperatorsByCountryContext(context.Background(), country)
+}
+
+func (s *TopupsService) OperatorsByCountryContext(ctx context.Context, country string) ([]Operator, error) {
 	path := fmt.Sprintf("/operators/countries/%v", country)
 	params := &OperatorsParams{SuggestedAmountsMap: true, SuggestedAmounts: true}
 	resp := new([]Operator)
-	_, err := s.Request("GET", path, params, resp)
+	_, err := s.RequestContext(ctx, "GET", path, params, resp)
 	return *resp, err
 }
 
 func (s *TopupsService) SearchOperator(country, name string) (*Operator, error) {
-	ops, err := s.OperatorsByCountry(country)
+	return s.SearchOperatorContext(context.Background(), country, name)
+}
+
+func (s *TopupsService) SearchOperatorContext(ctx context.Context, country, name string) (*Operator, error) {
+	ctx, span := s.startSpan(ctx, "SearchOperator",
+		attribute.String("reloadly.country", country),
+		attribute.String("reloadly.operator_name", name))
+
+	op, err := s.searchOperator(ctx, country, name)
+	if err == nil {
+		span.SetAttributes(operatorAttributes(op)...)
+	}
+	endSpan(span, err)
+	return op, err
+}
+
+func (s *TopupsService) searchOperator(ctx context.Context, country, name string) (*Operator, error) {
+	ops, err := s.OperatorsByCountryContext(ctx, country)
 	if err != nil {
 		return nil, err
 	}
@@ -225,9 +260,13 @@ func (s *TopupsService) SearchOperator(country, name string) (*Operator, error)
 }
 
 func (s *TopupsService) GetOperatorByID(operatorID int64) (*Operator, error) {
+	return s.GetOperatorByIDContext(context.Background(), operatorID)
+}
+
+func (s *TopupsService) GetOperatorByIDContext(ctx context.Context, operatorID int64) (*Operator, error) {
 	path := fmt.Sprintf("/operators/%v", operatorID)
 	params := &OperatorsParams{SuggestedAmountsMap: true, SuggestedAmounts: true}
 	resp := new(Operator)
-	_, err := s.Request("GET", path, params, resp)
+	_, err := s.RequestContext(ctx, "GET", path, params, resp)
 	return resp, err
 }
@@ -0,0 +1,89 @@
+package reloadly
+
+import (
+	"context"
+	"math"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TopupQuote is what a topup would send and cost, worked out from its
+// operator without sending it.
+type TopupQuote struct {
+	RecipientPhone   string `csv:"recipientPhone" json:"recipientPhone,omitempty"`
+	CountryCode      string `csv:"countryCode" json:"countryCode,omitempty"`
+	CustomIdentifier string `csv:"customIdentifier" json:"customIdentifier,omitempty"`
+	OperatorID       int64  `csv:"operatorId" json:"operatorId,omitempty"`
+	OperatorName     string `csv:"operatorName" json:"operatorName,omitempty"`
+	DenominationType string `csv:"denominationType" json:"denominationType,omitempty"`
+
+	// Amount is the amount that would be sent, in the sender
+	// currency: the denomination picked when using suggested
+	// amounts, or the requested amount otherwise.
+	Amount float64 `csv:"amount" json:"amount"`
+
+	// Fee and Discount are worked out from the operator's
+	// international fees and discount, and Cost is what the topup
+	// would take from the balance: Amount + Fee - Discount.
+	Fee          float64 `csv:"fee" json:"fee"`
+	Discount     float64 `csv:"discount" json:"discount"`
+	Cost         float64 `csv:"cost" json:"cost"`
+	CurrencyCode string  `csv:"currencyCode" json:"currencyCode,omitempty"`
+
+	// DeliveredAmount is exact for fixed denominations, and
+	// converted with the operator's fx rate for ranges.
+	DeliveredAmount             float64 `csv:"deliveredAmount" json:"deliveredAmount"`
+	DeliveredAmountCurrencyCode string  `csv:"deliveredAmountCurrencyCode" json:"deliveredAmountCurrencyCode,omitempty"`
+}
+
+func roundCents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func newTopupQuote(op *Operator, req *TopupRequest) *TopupQuote {
+	q := &TopupQuote{
+		RecipientPhone:              req.RecipientPhone.Number,
+		CountryCode:                 req.RecipientPhone.CountryCode,
+		CustomIdentifier:            req.CustomIdentifier,
+		OperatorID:                  op.OperatorID,
+		OperatorName:                op.Name,
+		DenominationType:            op.DenominationType,
+		Amount:                      req.Amount,
+		CurrencyCode:                op.SenderCurrencyCode,
+		DeliveredAmount:             roundCents(req.Amount * op.Fx.Rate),
+		DeliveredAmountCurrencyCode: op.DestinationCurrencyCode,
+	}
+
+	if op.DenominationType != "RANGE" {
+		for _, a := range op.SuggestedAmountsMap {
+			if a.Pay == req.Amount {
+				q.DeliveredAmount = a.Sent
+			}
+		}
+	}
+
+	q.Fee = roundCents(op.Fees.International + req.Amount*op.Fees.InternationalPercentage/100)
+	q.Discount = roundCents(req.Amount * op.InternationalDiscount / 100)
+	q.Cost = roundCents(q.Amount + q.Fee - q.Discount)
+	return q
+}
+
+func (s *TopupsService) Quote(mobile string, requestedAmount float64) (*TopupQuote, error) {
+	return s.QuoteContext(context.Background(), mobile, requestedAmount)
+}
+
+// QuoteContext resolves the operator and amount of a topup exactly as
+// TopupContext would, but returns what it would send and cost rather
+// than sending it. Auto-fallback does not apply, as nothing is sent.
+func (s *TopupsService) QuoteContext(ctx context.Context, mobile string, requestedAmount float64) (*TopupQuote, error) {
+	ctx, span := s.startSpan(ctx, "Quote", attribute.Float64("reloadly.requested_amount", requestedAmount))
+
+	req, err := s.prepare(ctx, mobile, requestedAmount)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	endSpan(span, nil)
+	return newTopupQuote(s.operator, req), nil
+}
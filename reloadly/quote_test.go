@@ -0,0 +1,76 @@
+package reloadly
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteFixedOperatorWithSuggestedAmount(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/airtel.json")
+	airtel := string(dat)
+
+	ts, mux := TestServerMux()
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("quote sent a topup")
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	q, err := svc.Topups().AutoDetect("IN").SuggestedAmount(0).CustomIdentifier("row-1").Quote("+123", 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &TopupQuote{
+		RecipientPhone:              "+123",
+		CountryCode:                 "IN",
+		CustomIdentifier:            "row-1",
+		OperatorID:                  200,
+		OperatorName:                "Airtel India",
+		DenominationType:            "FIXED",
+		Amount:                      1.82,
+		Fee:                         0,
+		Discount:                    0.15,
+		Cost:                        1.67,
+		CurrencyCode:                "USD",
+		DeliveredAmount:             100,
+		DeliveredAmountCurrencyCode: "INR",
+	}, q)
+}
+
+func TestQuoteRangeOperator(t *testing.T) {
+	min, max := 1.0, 100.0
+	op := &Operator{
+		OperatorID:              1,
+		DenominationType:        "RANGE",
+		MinAmount:               &min,
+		MaxAmount:               &max,
+		SenderCurrencyCode:      "USD",
+		DestinationCurrencyCode: "XYZ",
+		InternationalDiscount:   2,
+		Country:                 Country{IsoName: "XY"},
+		Fx:                      Fx{Rate: 10},
+		Fees:                    Fees{International: 0.5, InternationalPercentage: 1},
+	}
+
+	svc := &Service{}
+	q, err := svc.Topups().Operator(op).SuggestedAmount(0).Quote("+123", 50)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5.0, q.Amount)
+	assert.Equal(t, 0.55, q.Fee)
+	assert.Equal(t, 0.1, q.Discount)
+	assert.Equal(t, 5.45, q.Cost)
+	assert.Equal(t, 50.0, q.DeliveredAmount)
+}
+
+func TestQuoteFailsLikeTopup(t *testing.T) {
+	svc := &Service{}
+	_, err := svc.Topups().Quote("+123", 50)
+	assert.ErrorIs(t, err, ErrInvalidCall)
+}
@@ -0,0 +1,178 @@
+package reloadly
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// EndpointClass groups Reloadly endpoints which can be given their
+// own rate limit.
+type EndpointClass string
+
+const (
+	EndpointAuth      EndpointClass = "auth"
+	EndpointOperators EndpointClass = "operators"
+	EndpointTopup     EndpointClass = "topup"
+	EndpointOther     EndpointClass = "other"
+)
+
+func classifyEndpoint(method, path string) EndpointClass {
+	switch {
+	case strings.HasPrefix(path, "/oauth/"):
+		return EndpointAuth
+	case strings.HasPrefix(path, "/operators"):
+		return EndpointOperators
+	case strings.ToUpper(method) == "POST" && strings.HasPrefix(path, "/topups"):
+		return EndpointTopup
+	default:
+		return EndpointOther
+	}
+}
+
+// rateLimits throttles the requests of a Service. Like the token
+// store, it is referenced by pointer and so shared by every copy.
+type rateLimits struct {
+	mu          sync.Mutex
+	all         *rate.Limiter
+	classes     map[EndpointClass]*rate.Limiter
+	slots       chan struct{}
+	pausedUntil time.Time
+}
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{classes: map[EndpointClass]*rate.Limiter{}}
+}
+
+func (s *Service) rateLimits() *rateLimits {
+	return lazyLoad(&s.limits, newRateLimits)
+}
+
+// newLimiter returns a limiter of rps requests per second, with
+// bursts of up to burst requests (at least 1), or nil for no limit if
+// rps is not positive.
+func newLimiter(rps float64, burst int) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// SetRateLimit limits the Service, and every copy of it, to rps
+// requests per second, with bursts of up to burst requests. A burst
+// below 1 is taken as 1, and an rps of 0 (or less) removes the limit.
+func (s *Service) SetRateLimit(rps float64, burst int) {
+	l := s.rateLimits()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.all = newLimiter(rps, burst)
+}
+
+// SetEndpointRateLimit limits requests to one class of endpoints.
+// It applies on top of the limit set with SetRateLimit, and takes
+// rps and burst like it.
+func (s *Service) SetEndpointRateLimit(class EndpointClass, rps float64, burst int) {
+	l := s.rateLimits()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter := newLimiter(rps, burst); limiter != nil {
+		l.classes[class] = limiter
+	} else {
+		delete(l.classes, class)
+	}
+}
+
+// SetMaxConcurrency caps the number of requests in flight at once.
+// A cap of 0 (or less) removes it.
+func (s *Service) SetMaxConcurrency(n int) {
+	l := s.rateLimits()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n <= 0 {
+		l.slots = nil
+		return
+	}
+	l.slots = make(chan struct{}, n)
+}
+
+// acquire waits until a request to the given endpoint may be sent.
+// The returned function must be called once the request is done.
+func (l *rateLimits) acquire(ctx context.Context, method, path string) (func(), error) {
+	l.mu.Lock()
+	limiters := []*rate.Limiter{l.all, l.classes[classifyEndpoint(method, path)]}
+	slots := l.slots
+	pause := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+
+	if pause > 0 {
+		if err := sleepContext(ctx, pause); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			if e := contextError(ctx); e != nil {
+				return nil, e
+			}
+			return nil, err
+		}
+	}
+
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, CanceledError{Err: ctx.Err()}
+	}
+}
+
+// pause holds back every request until d has passed, e.g. when
+// Reloadly asks us to with a Retry-After header.
+func (l *rateLimits) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses,
+// which is either a number of seconds or an http date.
+func retryAfter(res *http.Response) time.Duration {
+	if res == nil || (res.StatusCode != 429 && res.StatusCode != 503) {
+		return 0
+	}
+
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package reloadly
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyEndpoint(t *testing.T) {
+	assert.Equal(t, EndpointAuth, classifyEndpoint("POST", "/oauth/token"))
+	assert.Equal(t, EndpointOperators, classifyEndpoint("GET", "/operators/countries/IN"))
+	assert.Equal(t, EndpointTopup, classifyEndpoint("POST", "/topups"))
+	assert.Equal(t, EndpointOther, classifyEndpoint("GET", "/accounts/balance"))
+}
+
+func TestRetryAfterParsesSecondsAndDates(t *testing.T) {
+	res := &http.Response{StatusCode: 429, Header: http.Header{}}
+	res.Header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, retryAfter(res))
+
+	res.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	d := retryAfter(res)
+	assert.True(t, d > 55*time.Second && d <= time.Minute)
+
+	res.StatusCode = 400
+	assert.Equal(t, time.Duration(0), retryAfter(res))
+}
+
+func TestRateLimitSpacesOutRequests(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetRateLimit(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := svc.Request("GET", "/foo", nil, new(struct{ Bar string }))
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) >= 190*time.Millisecond)
+}
+
+func TestEndpointRateLimitOnlyAppliesToItsClass(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetEndpointRateLimit(EndpointTopup, 1, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := svc.Request("GET", "/foo", nil, new(struct{ Bar string }))
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestMaxConcurrencyCapsRequestsInFlight(t *testing.T) {
+	var inflight, maxInflight int32
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetMaxConcurrency(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := TopupWorker(*svc)
+			copied := Service(worker)
+			copied.Request("GET", "/foo", nil, new(struct{ Bar string }))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInflight))
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	count := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	waits := []time.Duration{}
+	policy := testRetryPolicy()
+	policy.OnRetry = func(a RetryAttempt) { waits = append(waits, a.Wait) }
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, RetryPolicy: policy}
+	_, err := svc.Request("POST", "/topups", nil, new(struct{ Bar string }))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, waits)
+}
+
+func TestRetryAfterPausesEveryTopupWorkerJob(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/airtel.json")
+	airtel := string(dat)
+	ts, mux := TestServerMux()
+
+	var detects int32
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&detects, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(429)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"requestedAmount": 100, "requestedAmountCurrencyCode": "INR"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	worker := TopupWorker(*svc)
+
+	start := time.Now()
+	res := worker.Do(&TopupJob{Number: "+123", Amount: 100, Country: "IN"})
+	assert.Equal(t, "429", res.ErrorCode)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := worker.Do(&TopupJob{Number: "+123", Amount: 100, Country: "IN"})
+			assert.Equal(t, "", res.ErrorCode)
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, time.Since(start) >= 900*time.Millisecond)
+}
+
+func TestRateLimitSettersTreatZeroAsNoLimit(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetMaxConcurrency(0)
+	svc.SetRateLimit(1000, 0)
+	svc.SetEndpointRateLimit(EndpointOther, 0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.RequestContext(ctx, "GET", "/foo", nil, new(struct{ Bar string }))
+		assert.Nil(t, err)
+	}
+
+	svc.SetRateLimit(0, 0)
+	assert.Nil(t, svc.rateLimits().all)
+	assert.Nil(t, svc.rateLimits().slots)
+}
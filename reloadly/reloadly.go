@@ -1,46 +1,248 @@
 package reloadly
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/dghubble/sling"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Service struct {
 	Client       *http.Client
 	BaseUrl      string
 	AuthUrl      string
-	Token        *Token
-	id           string
-	secret       string
+	tokens       *tokenStore
+	limits       *rateLimits
 	sandboxUrl   string
 	acceptHeader string
+
+	// TokenRefreshSkew is how long before expiry the token is
+	// refreshed. Defaults to DefaultTokenRefreshSkew.
+	TokenRefreshSkew time.Duration
+
+	// RetryPolicy, if set, retries requests which fail with
+	// transient errors.
+	RetryPolicy *RetryPolicy
+
+	// Middlewares see every request the Service makes. See Use.
+	Middlewares []Middleware
+
+	// Logger, if set, receives the Service's logs: requests at
+	// debug level, token refreshes, auto-detection and fallbacks at
+	// info, and retries at warn.
+	Logger *slog.Logger
+
+	// TracerProvider, if set, is used for the spans around each
+	// operation and http call. Defaults to the global provider.
+	TracerProvider trace.TracerProvider
+
+	// IdempotentTopups makes every topup with a custom identifier
+	// idempotent. See TopupsService.Idempotent.
+	IdempotentTopups bool
+
+	// Metrics, if set, counts requests, topups, fallbacks and token
+	// refreshes. See NewMetrics.
+	Metrics *Metrics
+}
+
+// lazyMu guards the creation of the token store and rate limits of
+// Services that were not made with NewTopups or NewGiftCards, such as
+// &Service{} literals. Once created they are never replaced, so they
+// are read with an atomic load and without the lock.
+var lazyMu sync.Mutex
+
+// lazyLoad returns *p, first setting it to create() if it is nil.
+func lazyLoad[T any](p **T, create func() *T) *T {
+	ptr := (*unsafe.Pointer)(unsafe.Pointer(p))
+	if v := atomic.LoadPointer(ptr); v != nil {
+		return (*T)(v)
+	}
+	lazyMu.Lock()
+	defer lazyMu.Unlock()
+	if v := atomic.LoadPointer(ptr); v != nil {
+		return (*T)(v)
+	}
+	v := create()
+	atomic.StorePointer(ptr, unsafe.Pointer(v))
+	return v
+}
+
+// share returns a copy of the Service, first creating the token store
+// and rate limits it shares with its copies, so that they are shared
+// even if s is a literal.
+func (s *Service) share() Service {
+	s.store()
+	s.rateLimits()
+	return *s
 }
 
 func (s *Service) Sandbox() {
 	s.BaseUrl = s.sandboxUrl
 }
 
-func (s *Service) request(sli *sling.Sling, method, path string, params interface{}, resp interface{}) (*http.Response, error) {
+// contextError returns a CanceledError if ctx is done, so that
+// callers can tell cancellation apart from transport failures.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return CanceledError{Err: err}
+	}
+	return nil
+}
+
+// setMethod sets the method and path of sli. Params are encoded as
+// the query string for methods without a body, and as a JSON body
+// otherwise.
+func setMethod(sli *sling.Sling, method, path string, params interface{}) (*sling.Sling, error) {
 	switch strings.ToUpper(method) {
 	case "GET":
-		sli = sli.Get(path).QueryStruct(params)
+		return sli.Get(path).QueryStruct(params), nil
+	case "HEAD":
+		return sli.Head(path).QueryStruct(params), nil
+	case "DELETE":
+		return sli.Delete(path).QueryStruct(params), nil
 	case "POST":
-		sli = sli.Post(path).BodyJSON(params)
+		return sli.Post(path).BodyJSON(params), nil
+	case "PUT":
+		return sli.Put(path).BodyJSON(params), nil
+	case "PATCH":
+		return sli.Patch(path).BodyJSON(params), nil
+	default:
+		return nil, fmt.Errorf("unsupported http method: %v", method)
+	}
+}
+
+func (s *Service) request(ctx context.Context, sli *sling.Sling, method, path string, params interface{}, resp interface{}) (*http.Response, error) {
+	sli, err := setMethod(sli, method, path, params)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := sli.Request()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.do(ctx, sli, req, resp)
+}
+
+// do sends req, decoding a successful response into resp and an
+// unsuccessful one into an APIError.
+func (s *Service) do(ctx context.Context, sli *sling.Sling, req *http.Request, resp interface{}) (*http.Response, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, span := s.startHTTPSpan(ctx, req)
+	httpResponse, err := s.doTraced(ctx, sli, req, resp)
+	if httpResponse != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", httpResponse.StatusCode))
+	}
+	endSpan(span, err)
+	return httpResponse, err
+}
+
+// doTraced is the part of do inside the http span: waiting for the
+// rate limits, middleware, sending, metrics and logs.
+func (s *Service) doTraced(ctx context.Context, sli *sling.Sling, req *http.Request, resp interface{}) (*http.Response, error) {
+	release, err := s.rateLimits().acquire(ctx, req.Method, req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req = req.WithContext(ctx)
+	if err := s.beforeRequest(req); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	httpResponse, err := s.send(ctx, sli, req, resp)
+	elapsed := time.Since(start)
+
+	status := 0
+	if httpResponse != nil {
+		status = httpResponse.StatusCode
+	}
+	s.Metrics.observeRequest(req.Method, req.URL.Path, status, err, elapsed)
+
+	if httpResponse != nil {
+		s.afterResponse(req, httpResponse, elapsed)
+		s.logger().DebugContext(ctx, "reloadly request", "method", req.Method, "path", logPath(req.URL.Path), "status", httpResponse.StatusCode, "elapsed", elapsed)
+	}
+	if err != nil {
+		s.onError(req, err, elapsed)
+		s.logger().DebugContext(ctx, "reloadly request failed", "method", req.Method, "path", logPath(req.URL.Path), "error", logError(err), "elapsed", elapsed)
+	}
+	return httpResponse, err
+}
+
+// errorBodyDecoder decodes JSON like sling's default decoder, but
+// keeps the raw body of error responses.
+type errorBodyDecoder struct {
+	body *[]byte
+}
+
+func (d errorBodyDecoder) Decode(res *http.Response, v interface{}) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return json.NewDecoder(res.Body).Decode(v)
 	}
 
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	*d.body = b
+	return json.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func (s *Service) send(ctx context.Context, sli *sling.Sling, req *http.Request, resp interface{}) (*http.Response, error) {
 	apiError := APIError{}
-	httpResponse, err := sli.Receive(resp, &apiError)
+	var body []byte
+	httpResponse, err := sli.New().ResponseDecoder(errorBodyDecoder{&body}).Do(req, resp, &apiError)
+
+	// An error body that is empty, or not JSON (e.g. the html page
+	// of a gateway), fails to decode, but is handled below as an
+	// error without a body, so that its status is kept.
+	if err != nil && ctx.Err() == nil && httpResponse != nil && (httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299) {
+		apiError = APIError{}
+		err = nil
+	}
+
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, CanceledError{Err: ctx.Err(), InFlight: true}
+		}
+		if httpResponse == nil {
+			return nil, TransportError{err}
+		}
 		return nil, err
 	}
 
 	status := httpResponse.StatusCode
 
+	wait := retryAfter(httpResponse)
+	if wait > 0 {
+		s.rateLimits().pause(wait)
+	}
+
 	if !apiError.Empty() {
-		apiError = apiError.AddStatus(status)
+		apiError = apiError.AddStatus(status).addRequest(req, httpResponse, body)
+		apiError.RetryAfter = wait
 		return httpResponse, apiError
 	}
 
@@ -49,38 +251,156 @@ func (s *Service) request(sli *sling.Sling, method, path string, params interfac
 	// own "APIError" from the status.
 	// TODO: remember when they do this...
 	if status < 200 || status > 299 {
-		return httpResponse, APIError{
+		apiError = APIError{
 			Message:    httpResponse.Status,
 			ErrorCode:  fmt.Sprint(status),
 			StatusCode: status,
+			RetryAfter: wait,
 		}
+		return httpResponse, apiError.addRequest(req, httpResponse, body)
 	}
 	return httpResponse, nil
 }
 
+// Request sends a request to path, relative to BaseUrl. Params are
+// sent as the query string for GET, HEAD and DELETE, and as a JSON
+// body for POST, PUT and PATCH. The response is decoded into resp.
 func (s *Service) Request(method, path string, params interface{}, resp interface{}) (*http.Response, error) {
+	return s.RequestContext(context.Background(), method, path, params, resp)
+}
+
+// RequestContext is like Request, but the request (and any re-auth
+// or retries it triggers) is bound to ctx.
+func (s *Service) RequestContext(ctx context.Context, method, path string, params interface{}, resp interface{}) (*http.Response, error) {
+	return s.requestWithPolicy(ctx, s.RetryPolicy, method, path, params, resp)
+}
+
+func (s *Service) requestWithPolicy(ctx context.Context, policy *RetryPolicy, method, path string, params interface{}, resp interface{}) (*http.Response, error) {
+	build := func() (*http.Request, error) {
+		sli, err := setMethod(sling.New().Base(s.BaseUrl), method, path, params)
+		if err != nil {
+			return nil, err
+		}
+		return sli.Request()
+	}
+
+	return s.withRetries(ctx, policy, method, path, func() (*http.Response, error) {
+		return s.sendAuthorized(ctx, build, resp)
+	})
+}
+
+// Do sends a request built by the caller, e.g. for endpoints this
+// library does not wrap yet. A relative url is resolved against
+// BaseUrl, and the request gets the same Accept and Authorization
+// headers, error decoding, token refresh, rate limits and retries as
+// any other. It is bound to the request's context.
+func (s *Service) Do(req *http.Request, resp interface{}) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+
+	if !req.URL.IsAbs() {
+		base, err := url.Parse(s.BaseUrl)
+		if err != nil {
+			return nil, err
+		}
+		req.URL = base.ResolveReference(req.URL)
+		req.Host = ""
+	}
+
+	// The body may need to be sent more than once,
+	// on re-auth or retries, so keep a copy of it.
+	if req.Body != nil && req.GetBody == nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+	}
 
-	op := func() (*http.Response, error) {
-		sli := sling.New().Client(s.Client).Base(s.BaseUrl).Set("Accept", s.acceptHeader)
+	build := func() (*http.Request, error) {
+		r := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		return r, nil
+	}
+
+	return s.withRetries(ctx, s.RetryPolicy, req.Method, req.URL.Path, func() (*http.Response, error) {
+		return s.sendAuthorized(ctx, build, resp)
+	})
+}
+
+// withRetries calls send until it succeeds or the policy gives up.
+func (s *Service) withRetries(ctx context.Context, policy *RetryPolicy, method, path string, send func() (*http.Response, error)) (*http.Response, error) {
+	if policy == nil {
+		return send()
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		httpResponse, err := send()
+
+		wait, ok := policy.next(method, attempt, time.Since(start), err)
+		if !ok {
+			return httpResponse, err
+		}
+
+		s.logger().WarnContext(ctx, "retrying reloadly request", "method", method, "path", logPath(path), "attempt", attempt, "wait", wait, "error", logError(err))
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(RetryAttempt{method, path, attempt, err, wait})
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sendAuthorized sends the request made by build with the current
+// token. If Reloadly says the token has expired, it re-authorizes
+// and sends a newly built request one more time.
+func (s *Service) sendAuthorized(ctx context.Context, build func() (*http.Request, error), resp interface{}) (*http.Response, error) {
+	token, err := s.currentToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	op := func(token *Token) (*http.Response, error) {
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Header.Get("Accept") == "" && s.acceptHeader != "" {
+			req.Header.Set("Accept", s.acceptHeader)
+		}
 
-		if s.Token != nil {
-			auth := fmt.Sprintf("%v %v", s.Token.TokenType, s.Token.AccessToken)
-			sli = sli.Set("Authorization", auth)
+		if token != nil {
+			auth := fmt.Sprintf("%v %v", token.TokenType, token.AccessToken)
+			req.Header.Set("Authorization", auth)
 		}
 
-		return s.request(sli, method, path, params, resp)
+		return s.do(ctx, sling.New().Client(s.Client), req, resp)
 	}
 
-	httpResponse, err := op()
+	httpResponse, err := op(token)
 
 	// If expired, try redoing the operation one time
 	if err != nil {
-		if e, ok := err.(APIError); ok && e.ErrorCode == "TOKEN_EXPIRED" {
-			err = s.ReAuth()
+		if errors.Is(err, ErrTokenExpired) {
+			token, err = s.reAuthFrom(ctx, token)
 			if err != nil {
 				return nil, err
 			}
-			return op()
+			return op(token)
 		}
 	}
 
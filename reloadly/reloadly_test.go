@@ -1,9 +1,12 @@
 package reloadly
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/dghubble/sling"
@@ -22,7 +25,7 @@ func TestRequestGetNoQueryParams(t *testing.T) {
 
 	svc := &Service{}
 	resp := new(struct{ Bar string })
-	_, err := svc.request(testSling, "GET", "/foo", new(struct{}), resp)
+	_, err := svc.request(context.Background(), testSling, "GET", "/foo", new(struct{}), resp)
 
 	assert.Nil(t, err)
 	assert.Equal(t, "baz", resp.Bar)
@@ -39,7 +42,7 @@ func TestRequestGetReturnsErrors(t *testing.T) {
 
 	svc := &Service{}
 	resp := new(struct{ Bar string })
-	_, err := svc.request(testSling, "GET", "/foo", new(struct{}), resp)
+	_, err := svc.request(context.Background(), testSling, "GET", "/foo", new(struct{}), resp)
 
 	assert.NotNil(t, err)
 	e, ok := err.(APIError)
@@ -60,7 +63,7 @@ func TestRequestGetReturnsErrorsWithoutErrorCode(t *testing.T) {
 
 	svc := &Service{}
 	resp := new(struct{ Bar string })
-	_, err := svc.request(testSling, "GET", "/foo", new(struct{}), resp)
+	_, err := svc.request(context.Background(), testSling, "GET", "/foo", new(struct{}), resp)
 
 	assert.NotNil(t, err)
 	e, ok := err.(APIError)
@@ -73,7 +76,7 @@ func TestRequestGetReturnsErrorsWithoutErrorCode(t *testing.T) {
 func TestRequestGetReturnsErrorsOnHttpError(t *testing.T) {
 	svc := &Service{}
 	resp := new(struct{ Bar string })
-	_, err := svc.request(sling.New().Client(&http.Client{}).Base("http://foo"), "GET", "/foo", new(struct{}), resp)
+	_, err := svc.request(context.Background(), sling.New().Client(&http.Client{}).Base("http://foo"), "GET", "/foo", new(struct{}), resp)
 
 	assert.NotNil(t, err)
 	_, ok := err.(APIError)
@@ -91,13 +94,15 @@ func TestRequestGetReturnsSomethingReasonableWhenNotJSONResponse(t *testing.T) {
 
 	svc := &Service{}
 	resp := new(struct{ Bar string })
-	_, err := svc.request(testSling, "GET", "/foo", new(struct{}), resp)
+	_, err := svc.request(context.Background(), testSling, "GET", "/foo", new(struct{}), resp)
 
-	// Note: this is the default, is it reasonable? Maybe needs improving.
-	e, ok := err.(*json.SyntaxError)
+	// The body is kept, and the status used as the error code.
+	e, ok := err.(APIError)
 
 	assert.True(t, ok)
-	assert.NotNil(t, e)
+	assert.Equal(t, "401", e.ErrorCode)
+	assert.Equal(t, 401, e.StatusCode)
+	assert.Equal(t, `<html> stupid response </html>`, e.RawBody)
 }
 
 func TestRequestDoesReAuthOnErrorCodeTOKEN_EXPIRED(t *testing.T) {
@@ -147,3 +152,173 @@ func TestRequestDoesReAuthOnErrorCodeTOKEN_EXPIRED(t *testing.T) {
 	assert.Equal(t, 2, count)
 	<-done
 }
+
+func TestRequestContextReturnsCanceledErrorWhenContextCanceled(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	resp := new(struct{ Bar string })
+
+	go cancel()
+	_, err := svc.RequestContext(ctx, "GET", "/foo", new(struct{}), resp)
+
+	assert.NotNil(t, err)
+	_, ok := err.(CanceledError)
+	assert.True(t, ok)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestRequestContextDoesNotSendWhenContextAlreadyDone(t *testing.T) {
+	called := false
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	resp := new(struct{ Bar string })
+
+	_, err := svc.RequestContext(ctx, "GET", "/foo", new(struct{}), resp)
+
+	assert.False(t, called)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestRequestReturnsAPIErrorForEmptyErrorBody(t *testing.T) {
+	_, testSling := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})
+
+	svc := &Service{}
+	resp := new(struct{ Bar string })
+	_, err := svc.request(context.Background(), testSling, "GET", "/foo", new(struct{}), resp)
+
+	e, ok := err.(APIError)
+	assert.True(t, ok)
+	assert.Equal(t, "503", e.ErrorCode)
+	assert.Equal(t, 503, e.StatusCode)
+}
+
+func TestRequestSendsJsonBodyForPutAndPatch(t *testing.T) {
+	for _, method := range []string{"PUT", "PATCH"} {
+		ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, method, r.Method)
+			assert.Equal(t, "/foo", r.URL.Path)
+			assert.Equal(t, `{"Bar":"baz"}`, strings.TrimSpace(string(data)))
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"Bar": "qux"}`)
+		})
+
+		svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+		resp := new(struct{ Bar string })
+		_, err := svc.Request(method, "/foo", &struct{ Bar string }{"baz"}, resp)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "qux", resp.Bar)
+	}
+}
+
+func TestRequestSendsQueryForDelete(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "suggestedAmounts=true", r.URL.RawQuery)
+		w.WriteHeader(204)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	res, err := svc.Request("delete", "/foo", &OperatorsParams{SuggestedAmounts: true}, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+}
+
+func TestRequestErrorsOnUnsupportedMethod(t *testing.T) {
+	svc := &Service{BaseUrl: "http://foo", Client: &http.Client{}}
+	_, err := svc.Request("FOO", "/foo", nil, nil)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unsupported http method")
+}
+
+func TestDoResolvesRelativeUrlAndAddsHeaders(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/accounts/balance", r.URL.Path)
+		assert.Equal(t, "Bearer foo", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/com.reloadly.topups-v1+json", r.Header.Get("Accept"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, acceptHeader: "application/com.reloadly.topups-v1+json"}
+	svc.SetToken(&Token{TokenType: "Bearer", AccessToken: "foo"})
+
+	req, _ := http.NewRequest("GET", "/accounts/balance", nil)
+	resp := new(struct{ Bar string })
+	_, err := svc.Do(req, resp)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", resp.Bar)
+}
+
+func TestDoReturnsAPIErrors(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"message": "Bad", "errorCode": "INVALID_PARAMETER"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	req, _ := http.NewRequest("POST", "/foo", strings.NewReader(`{}`))
+	_, err := svc.Do(req, nil)
+
+	e, ok := err.(APIError)
+	assert.True(t, ok)
+	assert.Equal(t, "INVALID_PARAMETER", e.ErrorCode)
+	assert.Equal(t, 400, e.StatusCode)
+}
+
+func TestDoResendsBodyAfterReAuth(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	authCount := 0
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 86400}`, authCount)
+		authCount++
+	})
+
+	count := 0
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, `{"a":1}`, string(data))
+
+		w.Header().Set("Content-Type", "application/json")
+		if count == 0 {
+			w.WriteHeader(401)
+			fmt.Fprintf(w, `{"errorCode":"TOKEN_EXPIRED"}`)
+		} else {
+			assert.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+			fmt.Fprintf(w, `{"Bar": "qux"}`)
+		}
+		count++
+	})
+
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	req, _ := http.NewRequest("PUT", "/foo", ioutil.NopCloser(strings.NewReader(`{"a":1}`)))
+	resp := new(struct{ Bar string })
+	_, err = svc.Do(req, resp)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", resp.Bar)
+	assert.Equal(t, 2, count)
+}
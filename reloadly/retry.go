@@ -0,0 +1,183 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryRule describes how to retry a Reloadly error code.
+type RetryRule struct {
+	// NoCharge means Reloadly guarantees that nothing was charged
+	// when it returns this code, so it is safe to retry POSTs
+	// (e.g. topups) too. Otherwise only idempotent requests are
+	// retried.
+	NoCharge bool
+
+	// MinBackoff is the least time to wait before retrying this
+	// code, for errors that take a while to clear.
+	MinBackoff time.Duration
+}
+
+// RetryAttempt is passed to RetryPolicy.OnRetry before each retry.
+type RetryAttempt struct {
+	Method  string
+	Path    string
+	Attempt int
+	Err     error
+	Wait    time.Duration
+}
+
+// RetryPolicy decides which failed requests are retried and how long
+// to wait between attempts. Backoff grows exponentially from
+// InitialBackoff by Multiplier up to MaxBackoff, and is randomized by
+// +/- Jitter (a fraction of the backoff).
+type RetryPolicy struct {
+	// Rules holds the retryable error codes. Errors without a body
+	// have their http status as code, e.g. "429" or "503".
+	Rules map[string]RetryRule
+
+	// RetryTransportErrors retries idempotent requests that failed
+	// without a response, e.g. on connection errors.
+	RetryTransportErrors bool
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+
+	// MaxAttempts caps the number of attempts, including the first.
+	// MaxElapsed caps the total time spent, including waits. Zero
+	// means no cap.
+	MaxAttempts int
+	MaxElapsed  time.Duration
+
+	// ReportDelay is the least an idempotent topup waits, after a
+	// failure that may have been charged, before it checks the
+	// transactions report again, as Reloadly takes a while to add
+	// topups to it. Defaults to DefaultReportDelay.
+	ReportDelay time.Duration
+
+	// OnRetry, if set, is called before waiting for each retry.
+	OnRetry func(RetryAttempt)
+}
+
+// DefaultReportDelay is the ReportDelay of policies that do not set
+// one.
+const DefaultReportDelay = 30 * time.Second
+
+func (p *RetryPolicy) reportDelay() time.Duration {
+	if p.ReportDelay > 0 {
+		return p.ReportDelay
+	}
+	return DefaultReportDelay
+}
+
+// DefaultRetryPolicy returns a policy for the transient errors listed
+// in Reloadly's documentation.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Rules: map[string]RetryRule{
+			ErrPhoneRecentlyRecharged.ErrorCode:       {NoCharge: true, MinBackoff: time.Minute},
+			ErrTransactionCannotBeProcessed.ErrorCode: {NoCharge: true},
+			ErrServiceToOperatorUnavailable.ErrorCode: {NoCharge: true},
+			ErrProviderInternalError.ErrorCode:        {},
+			ErrTooManyRequests.ErrorCode:              {NoCharge: true},
+			"502":                                     {},
+			"503":                                     {},
+			"504":                                     {},
+		},
+		RetryTransportErrors: true,
+		InitialBackoff:       time.Second,
+		MaxBackoff:           time.Minute,
+		Multiplier:           2,
+		Jitter:               0.2,
+		MaxAttempts:          5,
+		MaxElapsed:           5 * time.Minute,
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// rule returns the rule to retry err with, if it should be retried
+// for a request with the given method.
+func (p *RetryPolicy) rule(method string, err error) (RetryRule, bool) {
+	if err == nil || errors.As(err, new(CanceledError)) || errors.As(err, new(ReloadlyError)) {
+		return RetryRule{}, false
+	}
+
+	var e APIError
+	if errors.As(err, &e) {
+		rule, ok := p.Rules[e.ErrorCode]
+		if !ok || (!rule.NoCharge && !isIdempotent(method)) {
+			return RetryRule{}, false
+		}
+		return rule, true
+	}
+
+	return RetryRule{}, p.RetryTransportErrors && isIdempotent(method)
+}
+
+func (p *RetryPolicy) backoff(attempt int, rule RetryRule) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if wait < float64(rule.MinBackoff) {
+		wait = float64(rule.MinBackoff)
+	}
+
+	wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(wait)
+}
+
+// next returns how long to wait before retrying after the given
+// (1-based) attempt failed with err, or false to give up.
+func (p *RetryPolicy) next(method string, attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	rule, ok := p.rule(method, err)
+	if !ok {
+		return 0, false
+	}
+
+	if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	wait := p.backoff(attempt, rule)
+	var e APIError
+	if errors.As(err, &e) && e.RetryAfter > wait {
+		wait = e.RetryAfter
+	}
+
+	if p.MaxElapsed > 0 && elapsed+wait > p.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return CanceledError{Err: ctx.Err()}
+	}
+}
@@ -0,0 +1,141 @@
+package reloadly
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRetryPolicy() *RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	p.Jitter = 0
+	p.ReportDelay = time.Millisecond
+	p.Rules["PHONE_RECENTLY_RECHARGED"] = RetryRule{NoCharge: true}
+	return p
+}
+
+func TestRetryPolicyBackoffGrowsAndCaps(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	assert.Equal(t, time.Second, p.backoff(1, RetryRule{}))
+	assert.Equal(t, 2*time.Second, p.backoff(2, RetryRule{}))
+	assert.Equal(t, 4*time.Second, p.backoff(3, RetryRule{}))
+	assert.Equal(t, 5*time.Second, p.backoff(4, RetryRule{}))
+	assert.Equal(t, time.Minute, p.backoff(1, RetryRule{MinBackoff: time.Minute}))
+}
+
+func TestRetryPolicyBackoffJitterStaysInRange(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		wait := p.backoff(1, RetryRule{})
+		assert.True(t, wait >= 500*time.Millisecond)
+		assert.True(t, wait <= 1500*time.Millisecond)
+	}
+}
+
+func TestRetryPolicyOnlyRetriesPostsThatWereNotCharged(t *testing.T) {
+	p := DefaultRetryPolicy()
+
+	_, ok := p.next("POST", 1, 0, APIError{ErrorCode: "PROVIDER_INTERNAL_ERROR"})
+	assert.False(t, ok)
+
+	_, ok = p.next("GET", 1, 0, APIError{ErrorCode: "PROVIDER_INTERNAL_ERROR"})
+	assert.True(t, ok)
+
+	_, ok = p.next("POST", 1, 0, APIError{ErrorCode: "TRANSACTION_CANNOT_BE_PROCESSED_AT_THE_MOMENT"})
+	assert.True(t, ok)
+
+	_, ok = p.next("POST", 1, 0, errors.New("connection reset"))
+	assert.False(t, ok)
+
+	_, ok = p.next("GET", 1, 0, errors.New("connection reset"))
+	assert.True(t, ok)
+
+	_, ok = p.next("GET", 1, 0, APIError{ErrorCode: "INVALID_RECIPIENT_PHONE"})
+	assert.False(t, ok)
+}
+
+func TestRetryPolicyStopsAtMaxAttemptsAndMaxElapsed(t *testing.T) {
+	p := DefaultRetryPolicy()
+	err := APIError{ErrorCode: "503"}
+
+	_, ok := p.next("GET", p.MaxAttempts, 0, err)
+	assert.False(t, ok)
+
+	_, ok = p.next("GET", 1, p.MaxElapsed, err)
+	assert.False(t, ok)
+}
+
+func TestRequestRetriesTransientErrors(t *testing.T) {
+	count := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	attempts := []RetryAttempt{}
+	policy := testRetryPolicy()
+	policy.OnRetry = func(a RetryAttempt) { attempts = append(attempts, a) }
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, RetryPolicy: policy}
+	resp := new(struct{ Bar string })
+	_, err := svc.Request("GET", "/foo", nil, resp)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", resp.Bar)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 2, len(attempts))
+	assert.Equal(t, 1, attempts[0].Attempt)
+	assert.Equal(t, "503", attempts[0].Err.(APIError).ErrorCode)
+}
+
+func TestTopupRetriesOnlyNoChargeErrors(t *testing.T) {
+	count := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"errorCode": "PROVIDER_INTERNAL_ERROR"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	op := Operator{Name: "Foodafone", OperatorID: 1, Country: Country{"IN", "India"}}
+	_, err := svc.Topups().Operator(&op).Retry(testRetryPolicy()).Topup("+123", 100)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestTopupRetriesRecentlyRecharged(t *testing.T) {
+	count := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.Header().Set("Content-Type", "application/json")
+		if count == 1 {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"errorCode": "PHONE_RECENTLY_RECHARGED"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"transactionId": 5}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	op := Operator{Name: "Foodafone", OperatorID: 1, Country: Country{"IN", "India"}}
+	res, err := svc.Topups().Operator(&op).Retry(testRetryPolicy()).Topup("+123", 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), res.TransactionID)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,132 @@
+package reloadly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// TokenSource supplies the tokens a Service uses to authorize its
+// requests. Implementations should return a fresh token on every
+// call; a Service caches the token itself and only asks again when
+// it is about to expire or Reloadly reports it as expired.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc adapts an ordinary function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource which always returns token, for
+// tokens that were issued elsewhere.
+func StaticToken(token *Token) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return token, nil
+	})
+}
+
+// FileToken returns a TokenSource which reads a JSON encoded Token
+// from path every time it is asked, so that another process (e.g. a
+// secrets manager sidecar) can keep the file up to date.
+func FileToken(path string) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		token := new(Token)
+		err = json.Unmarshal(b, token)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token from %v: %v", path, err)
+		}
+
+		if token.IssuedAt.IsZero() {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			token.IssuedAt = info.ModTime()
+		}
+		return token, nil
+	})
+}
+
+// CredentialsFrom returns a TokenSource which calls lookup for a
+// client id and secret whenever it needs a token, and exchanges them
+// for one at Reloadly's OAuth endpoint. The credentials are not kept
+// between calls.
+func (s *Service) CredentialsFrom(lookup func() (id, secret string, err error)) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		id, secret, err := lookup()
+		if err != nil {
+			return nil, err
+		}
+		if id == "" || secret == "" {
+			return nil, fmt.Errorf("missing Reloadly client id or secret")
+		}
+		return s.GetOAuthTokenContext(ctx, id, secret)
+	})
+}
+
+// ClientCredentials returns a TokenSource which exchanges the given
+// client id and secret for tokens.
+func (s *Service) ClientCredentials(id, secret string) TokenSource {
+	return s.CredentialsFrom(func() (string, string, error) {
+		return id, secret, nil
+	})
+}
+
+// EnvCredentials returns a TokenSource which reads the client id and
+// secret from the given environment variables.
+func (s *Service) EnvCredentials(idVar, secretVar string) TokenSource {
+	return s.CredentialsFrom(func() (string, string, error) {
+		return os.Getenv(idVar), os.Getenv(secretVar), nil
+	})
+}
+
+// FileCredentials returns a TokenSource which reads the client id and
+// secret from a JSON file shaped like an OAuthRequest, e.g.
+// {"client_id": "...", "client_secret": "..."}.
+func (s *Service) FileCredentials(path string) TokenSource {
+	return s.CredentialsFrom(func() (string, string, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", "", err
+		}
+
+		creds := new(OAuthRequest)
+		err = json.Unmarshal(b, creds)
+		if err != nil {
+			return "", "", fmt.Errorf("could not read credentials from %v: %v", path, err)
+		}
+		return creds.ID, creds.Secret, nil
+	})
+}
+
+// CachingTokenSource wraps a TokenSource, reusing its token until it
+// expires within Skew. It is safe for concurrent use, and concurrent
+// refreshes share a single call to the wrapped source.
+type CachingTokenSource struct {
+	Skew  time.Duration
+	store *tokenStore
+}
+
+func NewCachingTokenSource(source TokenSource, skew time.Duration) *CachingTokenSource {
+	return &CachingTokenSource{skew, &tokenStore{source: source}}
+}
+
+func (c *CachingTokenSource) Token(ctx context.Context) (*Token, error) {
+	token := c.store.get()
+	if !token.NeedsRefresh(c.Skew) {
+		return token, nil
+	}
+	return c.store.refresh(ctx, token, nil)
+}
@@ -0,0 +1,111 @@
+package reloadly
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenServer(t *testing.T, expected string) (*Service, *int) {
+	count := 0
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, expected, strings.TrimSpace(string(data)))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 86400}`, count)
+		count++
+	})
+
+	return &Service{AuthUrl: ts.URL, BaseUrl: "reloadly.com", Client: &http.Client{}}, &count
+}
+
+func TestEnvCredentialsReadsEnvironment(t *testing.T) {
+	expected := `{"client_id":"envid","client_secret":"envsecret","audience":"reloadly.com","grant_type":"client_credentials"}`
+	svc, _ := tokenServer(t, expected)
+
+	os.Setenv("TEST_RELOADLY_ID", "envid")
+	os.Setenv("TEST_RELOADLY_SECRET", "envsecret")
+	defer os.Unsetenv("TEST_RELOADLY_ID")
+	defer os.Unsetenv("TEST_RELOADLY_SECRET")
+
+	err := svc.AuthWith(svc.EnvCredentials("TEST_RELOADLY_ID", "TEST_RELOADLY_SECRET"))
+	assert.Nil(t, err)
+	assert.Equal(t, "token0", svc.Token().AccessToken)
+}
+
+func TestEnvCredentialsErrorsWhenMissing(t *testing.T) {
+	svc, count := tokenServer(t, "")
+
+	err := svc.AuthWith(svc.EnvCredentials("TEST_RELOADLY_NOPE", "TEST_RELOADLY_NOPE"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, *count)
+}
+
+func TestFileCredentialsReadsJsonFile(t *testing.T) {
+	expected := `{"client_id":"fileid","client_secret":"filesecret","audience":"reloadly.com","grant_type":"client_credentials"}`
+	svc, _ := tokenServer(t, expected)
+
+	path := filepath.Join(t.TempDir(), "creds.json")
+	ioutil.WriteFile(path, []byte(`{"client_id": "fileid", "client_secret": "filesecret"}`), 0600)
+
+	err := svc.AuthWith(svc.FileCredentials(path))
+	assert.Nil(t, err)
+	assert.Equal(t, "token0", svc.Token().AccessToken)
+}
+
+func TestFileTokenUsesTokenWithoutAuthRequest(t *testing.T) {
+	svc, count := tokenServer(t, "")
+
+	path := filepath.Join(t.TempDir(), "token.json")
+	ioutil.WriteFile(path, []byte(`{"token_type": "Bearer", "access_token": "fromfile", "expires_in": 3600}`), 0600)
+
+	err := svc.AuthWith(FileToken(path))
+	assert.Nil(t, err)
+	assert.Equal(t, "fromfile", svc.Token().AccessToken)
+	assert.False(t, svc.TokenExpiry().IsZero())
+	assert.Equal(t, 0, *count)
+}
+
+func TestStaticTokenIsUsedForRequests(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer static", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	svc.SetTokenSource(StaticToken(&Token{TokenType: "Bearer", AccessToken: "static"}))
+
+	resp := new(struct{ Bar string })
+	_, err := svc.Request("GET", "/foo", nil, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "qux", resp.Bar)
+}
+
+func TestCachingTokenSourceReusesTokenUntilExpiry(t *testing.T) {
+	count := 0
+	source := TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		count++
+		return &Token{AccessToken: fmt.Sprint(count), ExpiresIn: 600, IssuedAt: time.Now().Add(-6 * time.Minute)}, nil
+	})
+
+	cache := NewCachingTokenSource(source, time.Minute)
+	a, _ := cache.Token(context.Background())
+	b, _ := cache.Token(context.Background())
+	assert.Equal(t, a, b)
+	assert.Equal(t, 1, count)
+
+	cache.Skew = 20 * time.Minute
+	c, _ := cache.Token(context.Background())
+	assert.Equal(t, "2", c.AccessToken)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,106 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// tokenStore caches the token of a TokenSource. It is referenced by
+// pointer, so every copy of a Service (sub-services, TopupWorker)
+// shares it, and a single refresh serves them all.
+type tokenStore struct {
+	mu       sync.Mutex
+	token    *Token
+	source   TokenSource
+	inflight *tokenCall
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+func (t *tokenStore) get() *Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token
+}
+
+func (t *tokenStore) hasSource() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.source != nil
+}
+
+func (t *tokenStore) setToken(token *Token) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+}
+
+func (t *tokenStore) setSource(source TokenSource) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source = source
+	t.token = nil
+}
+
+// refresh replaces stale with a token from the source. If the stored
+// token is no longer stale, someone else already refreshed it and it
+// is returned as is. Concurrent callers share a single in-flight fetch,
+// and fetched, if not nil, is called once with its result.
+func (t *tokenStore) refresh(ctx context.Context, stale *Token, fetched func(*Token, error)) (*Token, error) {
+	for {
+		t.mu.Lock()
+		if t.token != stale {
+			token := t.token
+			t.mu.Unlock()
+			return token, nil
+		}
+
+		if t.source == nil {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("ReAuth failed as the Service has no token source")
+		}
+
+		c := t.inflight
+		if c == nil {
+			c = &tokenCall{done: make(chan struct{})}
+			t.inflight = c
+			source := t.source
+			t.mu.Unlock()
+
+			c.token, c.err = source.Token(ctx)
+			if fetched != nil {
+				fetched(c.token, c.err)
+			}
+
+			t.mu.Lock()
+			if c.err == nil {
+				t.token = c.token
+			}
+			t.inflight = nil
+			t.mu.Unlock()
+			close(c.done)
+
+			return c.token, c.err
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, CanceledError{Err: ctx.Err()}
+		}
+
+		// The fetch we waited on was abandoned by its own
+		// caller, but we are still live, so try again.
+		if errors.As(c.err, new(CanceledError)) && ctx.Err() == nil {
+			continue
+		}
+		return c.token, c.err
+	}
+}
@@ -0,0 +1,127 @@
+package reloadly
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenStoreRefreshSkipsFetchWhenAlreadyRefreshed(t *testing.T) {
+	stale := &Token{AccessToken: "stale"}
+	fresh := &Token{AccessToken: "fresh"}
+	fetch := func(ctx context.Context) (*Token, error) {
+		t.Error("fetch should not be called")
+		return nil, nil
+	}
+	store := &tokenStore{token: fresh, source: TokenSourceFunc(fetch)}
+
+	token, err := store.refresh(context.Background(), stale, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, fresh, token)
+}
+
+func TestTokenStoreRefreshKeepsTokenOnError(t *testing.T) {
+	stale := &Token{AccessToken: "stale"}
+	fetch := func(ctx context.Context) (*Token, error) {
+		return nil, fmt.Errorf("boom")
+	}
+	store := &tokenStore{token: stale, source: TokenSourceFunc(fetch)}
+
+	_, err := store.refresh(context.Background(), stale, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, stale, store.get())
+}
+
+func TestWorkerCopiesShareASingleTokenRefresh(t *testing.T) {
+	ts, mux := TestServerMux()
+
+	var authCount int32
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&authCount, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_type": "Bearer", "access_token": "token%v", "expires_in": 86400}`, n)
+	})
+
+	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer token1" {
+			w.WriteHeader(401)
+			fmt.Fprintf(w, `{"errorCode":"TOKEN_EXPIRED"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"Bar": "qux"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, AuthUrl: ts.URL, Client: &http.Client{}}
+	err := svc.Auth("id", "secret")
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := TopupWorker(*svc)
+			copied := Service(worker)
+			resp := new(struct{ Bar string })
+			_, err := copied.Topups().Request("GET", "/foo", nil, resp)
+			assert.Nil(t, err)
+			assert.Equal(t, "qux", resp.Bar)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&authCount))
+	assert.Equal(t, "token2", svc.Token().AccessToken)
+}
+
+func TestServiceLiteralSharesOneTokenStore(t *testing.T) {
+	svc := &Service{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc.SetToken(&Token{AccessToken: "foo"})
+			assert.Equal(t, "foo", svc.Token().AccessToken)
+		}()
+	}
+	wg.Wait()
+
+	// Jobs of a worker copied from a fresh literal share its store.
+	worker := TopupWorker(Service{})
+	copied := (*Service)(&worker).share()
+	copied.SetToken(&Token{AccessToken: "bar"})
+	assert.Equal(t, "bar", (*Service)(&worker).Token().AccessToken)
+}
+
+func TestCreatedStoreIsReadWithoutTheGlobalLock(t *testing.T) {
+	svc := &Service{}
+	svc.SetToken(&Token{AccessToken: "foo"})
+	svc.SetRateLimit(10, 1)
+
+	lazyMu.Lock()
+	defer lazyMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.Token()
+		svc.rateLimits()
+		svc.share()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reading a created store waited for lazyMu")
+	}
+}
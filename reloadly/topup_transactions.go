@@ -0,0 +1,215 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TopupTransactionsFilter selects topup transactions from the
+// transactions report. Zero values are not filtered on.
+type TopupTransactionsFilter struct {
+	Page int64
+	Size int64
+
+	CountryCode      string
+	OperatorID       int64
+	OperatorName     string
+	CustomIdentifier string
+
+	// StartDate and EndDate bound the transaction date.
+	StartDate time.Time
+	EndDate   time.Time
+
+	// RecipientPhone is not supported by the report endpoint, so it
+	// is applied to each page after it is fetched. Pages can
+	// therefore have fewer than Size transactions.
+	RecipientPhone string
+}
+
+type topupTransactionsParams struct {
+	Page             int64  `url:"page,omitempty"`
+	Size             int64  `url:"size,omitempty"`
+	CountryCode      string `url:"countryCode,omitempty"`
+	OperatorID       int64  `url:"operatorId,omitempty"`
+	OperatorName     string `url:"operatorName,omitempty"`
+	CustomIdentifier string `url:"customIdentifier,omitempty"`
+	StartDate        string `url:"startDate,omitempty"`
+	EndDate          string `url:"endDate,omitempty"`
+}
+
+func (f TopupTransactionsFilter) params() *topupTransactionsParams {
+	format := "2006-01-02 15:04:05"
+	p := &topupTransactionsParams{
+		Page:             f.Page,
+		Size:             f.Size,
+		CountryCode:      f.CountryCode,
+		OperatorID:       f.OperatorID,
+		OperatorName:     f.OperatorName,
+		CustomIdentifier: f.CustomIdentifier,
+	}
+
+	// Reloadly takes dates in UTC.
+	if !f.StartDate.IsZero() {
+		p.StartDate = f.StartDate.UTC().Format(format)
+	}
+	if !f.EndDate.IsZero() {
+		p.EndDate = f.EndDate.UTC().Format(format)
+	}
+	return p
+}
+
+type TopupTransactionsPage struct {
+	Content       []TopupResponse `json:"content,omitempty"`
+	Page          int64           `json:"page,omitempty"`
+	Size          int64           `json:"size,omitempty"`
+	TotalElements int64           `json:"totalElements,omitempty"`
+	TotalPages    int64           `json:"totalPages,omitempty"`
+	Last          bool            `json:"last,omitempty"`
+}
+
+func (s *TopupsService) Transactions(filter TopupTransactionsFilter) (TopupTransactionsPage, error) {
+	return s.TransactionsContext(context.Background(), filter)
+}
+
+// TransactionsContext gets a page of topup transactions from the
+// transactions report.
+func (s *TopupsService) TransactionsContext(ctx context.Context, filter TopupTransactionsFilter) (TopupTransactionsPage, error) {
+	resp := new(TopupTransactionsPage)
+	_, err := s.RequestContext(ctx, "GET", "/topups/reports/transactions", filter.params(), resp)
+	resp.Page = filter.Page
+
+	if filter.RecipientPhone != "" {
+		content := []TopupResponse{}
+		for _, t := range resp.Content {
+			if t.RecipientPhone == filter.RecipientPhone {
+				content = append(content, t)
+			}
+		}
+		resp.Content = content
+	}
+
+	return *resp, err
+}
+
+func (s *TopupsService) AllTransactions(filter TopupTransactionsFilter) ([]TopupResponse, error) {
+	return s.AllTransactionsContext(context.Background(), filter)
+}
+
+// AllTransactionsContext gets every page of topup transactions
+// matching the filter, starting from filter.Page.
+func (s *TopupsService) AllTransactionsContext(ctx context.Context, filter TopupTransactionsFilter) ([]TopupResponse, error) {
+	if filter.Page == 0 {
+		filter.Page = 1
+	}
+
+	all := []TopupResponse{}
+	for {
+		page, err := s.TransactionsContext(ctx, filter)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, page.Content...)
+
+		if page.Last || filter.Page >= page.TotalPages {
+			return all, nil
+		}
+		filter.Page++
+	}
+}
+
+func (s *TopupsService) Transaction(transactionID int64) (*TopupResponse, error) {
+	return s.TransactionContext(context.Background(), transactionID)
+}
+
+// TransactionContext gets a single topup transaction by its id.
+func (s *TopupsService) TransactionContext(ctx context.Context, transactionID int64) (*TopupResponse, error) {
+	path := fmt.Sprintf("/topups/reports/transactions/%v", transactionID)
+	resp := new(TopupResponse)
+	_, err := s.RequestContext(ctx, "GET", path, nil, resp)
+	return resp, err
+}
+
+func (s *TopupsService) FindTopupByCustomIdentifier(identifier string) (*TopupResponse, error) {
+	return s.FindTopupByCustomIdentifierContext(context.Background(), identifier)
+}
+
+// FindTopupByCustomIdentifierContext searches the transactions report
+// for the topup with the given custom identifier. It returns an error
+// matching ErrTopupNotFound if there is none.
+func (s *TopupsService) FindTopupByCustomIdentifierContext(ctx context.Context, identifier string) (*TopupResponse, error) {
+	if identifier == "" {
+		return nil, ReloadlyError{"INVALID_CALL", "You must give a custom identifier to find a topup by"}
+	}
+
+	page, err := s.TransactionsContext(ctx, TopupTransactionsFilter{Page: 1, Size: 10, CustomIdentifier: identifier})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range page.Content {
+		if t.CustomIdentifier == identifier {
+			return &t, nil
+		}
+	}
+	return nil, ReloadlyError{"TOPUP_NOT_FOUND", fmt.Sprintf("Could not find a topup with custom identifier: %v", identifier)}
+}
+
+// postIdempotent sends req at most once per custom identifier, see
+// TopupsService.Idempotent.
+func (s *TopupsService) postIdempotent(ctx context.Context, policy *RetryPolicy, req *TopupRequest) (*TopupResponse, error) {
+	if req.CustomIdentifier == "" {
+		return nil, ReloadlyError{"INVALID_CALL", "You must set a custom identifier for an idempotent topup"}
+	}
+
+	if policy == nil {
+		policy = DefaultRetryPolicy()
+	}
+
+	// postErr is the error of the last post, whose charge is
+	// uncertain, which must not be lost if the report cannot be
+	// checked after it.
+	var postErr error
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		found, err := s.FindTopupByCustomIdentifierContext(ctx, req.CustomIdentifier)
+		if err == nil {
+			s.logger().InfoContext(ctx, "found existing topup, not sending it again", "custom_identifier", req.CustomIdentifier, "transaction_id", found.TransactionID)
+			return found, nil
+		}
+		if !errors.Is(err, ErrTopupNotFound) {
+			if postErr != nil {
+				return nil, fmt.Errorf("%w (report lookup failed: %v)", postErr, err)
+			}
+			return nil, err
+		}
+
+		resp := new(TopupResponse)
+		_, err = s.requestWithPolicy(ctx, policy, "POST", "/topups", req, resp)
+		if err == nil || !IsChargeUncertain(err) {
+			return resp, err
+		}
+		postErr = err
+
+		// Give the report time to catch up before checking it,
+		// or the topup may be sent twice.
+		wait := policy.backoff(attempt, RetryRule{})
+		if delay := policy.reportDelay(); wait < delay {
+			wait = delay
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return resp, err
+		}
+		if policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed {
+			return resp, err
+		}
+
+		s.logger().WarnContext(ctx, "topup may have been charged, checking the report before sending it again", "custom_identifier", req.CustomIdentifier, "attempt", attempt, "wait", wait, "error", err)
+
+		if e := sleepContext(ctx, wait); e != nil {
+			return resp, err
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package reloadly
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopupTransactionsSendsFilters(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/topup_transactions.json")
+	transactions := string(dat)
+
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topups/reports/transactions", r.URL.Path)
+
+		q := r.URL.Query()
+		assert.Equal(t, "1", q.Get("page"))
+		assert.Equal(t, "2", q.Get("size"))
+		assert.Equal(t, "GB", q.Get("countryCode"))
+		assert.Equal(t, "535", q.Get("operatorId"))
+		assert.Equal(t, "2021-12-01 00:00:00", q.Get("startDate"))
+		assert.Equal(t, "2021-12-31 00:00:00", q.Get("endDate"))
+		assert.Equal(t, "", q.Get("customIdentifier"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, transactions)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	page, err := svc.Topups().Transactions(TopupTransactionsFilter{
+		Page:        1,
+		Size:        2,
+		CountryCode: "GB",
+		OperatorID:  535,
+		StartDate:   time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC),
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Content))
+	assert.Equal(t, int64(2), page.TotalPages)
+	assert.Equal(t, "batch-1-row-1", page.Content[0].CustomIdentifier)
+	assert.Equal(t, 3168.4, page.Content[0].RequestedAmount)
+	assert.Equal(t, time.Date(2021, 12, 6, 8, 0, 39, 0, time.UTC), time.Time(*page.Content[0].TransactionDate))
+}
+
+func TestTopupTransactionsFiltersByPhone(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/topup_transactions.json")
+	transactions := string(dat)
+
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, transactions)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	page, err := svc.Topups().Transactions(TopupTransactionsFilter{RecipientPhone: "447951631338"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Content))
+	assert.Equal(t, int64(2), page.Content[0].TransactionID)
+}
+
+func TestAllTopupTransactionsFollowsPages(t *testing.T) {
+	pages := []string{}
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"content": [{"transactionId": %v}], "totalPages": 3, "last": %v}`, page, page == "3")
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	all, err := svc.Topups().AllTransactions(TopupTransactionsFilter{Size: 1})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, pages)
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, int64(3), all[2].TransactionID)
+}
+
+func TestTopupTransactionGetsById(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topups/reports/transactions/42", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"transactionId": 42, "customIdentifier": "foo", "transactionDate": "2021-12-06 08:00:39"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	tr, err := svc.Topups().Transaction(42)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", tr.CustomIdentifier)
+}
+
+func TestTransactionDateMarshalsAsJSONString(t *testing.T) {
+	d := TransactionDate(time.Date(2021, 12, 6, 8, 0, 39, 0, time.UTC))
+	b, err := d.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `"2021-12-06 08:00:39"`, string(b))
+}
+
+func TestTransactionDatesWithAnOffsetAreSentInUTC(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2021-12-06T08:00:39+05:30")
+	assert.Nil(t, err)
+
+	p := TopupTransactionsFilter{StartDate: start, EndDate: start}.params()
+	assert.Equal(t, "2021-12-06 02:30:39", p.StartDate)
+	assert.Equal(t, "2021-12-06 02:30:39", p.EndDate)
+
+	d := TransactionDate(start)
+	b, err := d.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `"2021-12-06 02:30:39"`, string(b))
+
+	var parsed TransactionDate
+	assert.Nil(t, parsed.UnmarshalJSON(b))
+	assert.True(t, start.Equal(time.Time(parsed)))
+}
+
+func TestFindTopupByCustomIdentifier(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/topup_transactions.json")
+	transactions := string(dat)
+
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topups/reports/transactions", r.URL.Path)
+		assert.Equal(t, "batch-1-row-2", r.URL.Query().Get("customIdentifier"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, transactions)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	res, err := svc.Topups().FindTopupByCustomIdentifier("batch-1-row-2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), res.TransactionID)
+}
+
+func TestFindTopupByCustomIdentifierNotFound(t *testing.T) {
+	ts, _ := TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content": [], "totalPages": 0, "last": true}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	res, err := svc.Topups().FindTopupByCustomIdentifier("batch-1-row-3")
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrTopupNotFound)
+}
+
+// idempotentServer serves a transactions report holding the topups
+// posted so far, and fails the first few posts with a 500, charging
+// them if charge is set.
+func idempotentServer(failures int, charge bool) (*Service, *int) {
+	ts, mux := TestServerMux()
+	posted := []string{}
+	posts := 0
+
+	mux.HandleFunc("/topups/reports/transactions", func(w http.ResponseWriter, r *http.Request) {
+		content := "[]"
+		id := r.URL.Query().Get("customIdentifier")
+		for _, p := range posted {
+			if p == id {
+				content = fmt.Sprintf(`[{"transactionId": 10, "customIdentifier": "%v"}]`, id)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"content": %v, "last": true}`, content)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.Header().Set("Content-Type", "application/json")
+		if posts <= failures {
+			if charge {
+				posted = append(posted, "row-1")
+			}
+			w.WriteHeader(500)
+			fmt.Fprint(w, `{"errorCode": "PROVIDER_INTERNAL_ERROR", "message": "oops"}`)
+			return
+		}
+		posted = append(posted, "row-1")
+		fmt.Fprint(w, `{"transactionId": 11, "customIdentifier": "row-1"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, RetryPolicy: testRetryPolicy()}
+	return svc, &posts
+}
+
+func TestIdempotentTopupDoesNotSendExistingTopup(t *testing.T) {
+	svc, posts := idempotentServer(0, false)
+
+	_, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+
+	res, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), res.TransactionID)
+	assert.Equal(t, 1, *posts)
+}
+
+func TestIdempotentTopupFindsChargedTopupAfterServerError(t *testing.T) {
+	svc, posts := idempotentServer(1, true)
+
+	res, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), res.TransactionID)
+	assert.Equal(t, 1, *posts)
+}
+
+func TestIdempotentTopupSendsAgainIfNotCharged(t *testing.T) {
+	svc, posts := idempotentServer(2, false)
+
+	res, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), res.TransactionID)
+	assert.Equal(t, 3, *posts)
+}
+
+func TestIdempotentTopupGivesUpAfterMaxAttempts(t *testing.T) {
+	svc, posts := idempotentServer(10, false)
+	svc.RetryPolicy.MaxAttempts = 2
+
+	_, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.ErrorIs(t, err, ErrProviderInternalError)
+	assert.Equal(t, 2, *posts)
+}
+
+func TestIdempotentTopupNeedsCustomIdentifier(t *testing.T) {
+	svc, posts := idempotentServer(0, false)
+
+	_, err := svc.Topups().Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.ErrorIs(t, err, ErrInvalidCall)
+	assert.Equal(t, 0, *posts)
+}
+
+func TestServiceIdempotentTopupsOnlyAppliesWithCustomIdentifier(t *testing.T) {
+	svc, posts := idempotentServer(0, false)
+	svc.IdempotentTopups = true
+
+	_, err := svc.Topups().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	_, err = svc.Topups().CustomIdentifier("row-1").Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *posts)
+}
+
+func TestIdempotentTopupWaitsForReportBeforeCheckingAgain(t *testing.T) {
+	svc, posts := idempotentServer(1, true)
+	svc.RetryPolicy.ReportDelay = 200 * time.Millisecond
+
+	start := time.Now()
+	res, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), res.TransactionID)
+	assert.Equal(t, 1, *posts)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	assert.Equal(t, DefaultReportDelay, (&RetryPolicy{}).reportDelay())
+}
+
+func TestIdempotentTopupKeepsUncertainErrorWhenReportFails(t *testing.T) {
+	ts, mux := TestServerMux()
+	lookups, posts := 0, 0
+
+	mux.HandleFunc("/topups/reports/transactions", func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		w.Header().Set("Content-Type", "application/json")
+		if lookups > 1 {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"errorCode": "BAD_REQUEST", "message": "nope"}`)
+			return
+		}
+		fmt.Fprint(w, `{"content": [], "last": true}`)
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		fmt.Fprint(w, `{"errorCode": "PROVIDER_INTERNAL_ERROR", "message": "oops"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, RetryPolicy: testRetryPolicy()}
+	_, err := svc.Topups().CustomIdentifier("row-1").Idempotent().Operator(&Operator{OperatorID: 1}).Topup("+123", 10)
+
+	assert.True(t, IsChargeUncertain(err))
+	assert.ErrorIs(t, err, ErrProviderInternalError)
+	assert.Contains(t, err.Error(), "report lookup failed")
+	assert.Equal(t, 1, posts)
+}
@@ -1,9 +1,12 @@
 package reloadly
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/vlab-research/gotils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TopupWorker Service
@@ -51,23 +54,50 @@ type TopupJob struct {
 	Operator         string  `csv:"operator,omitempty" json:"operator,omitempty"`
 	ID               string  `csv:"id,omitempty" json:"id,omitempty"`
 	CustomIdentifier string  `csv:"custom_identifier,omitempty" json:"custom_identifier,omitempty"`
+
+	// DetectedOperator, if set, is the operator auto-detected for
+	// the number beforehand, which is used instead of detecting it
+	// again. It only applies to jobs without an Operator.
+	DetectedOperator *Operator `csv:"-" json:"-"`
 }
 
 type TopupWorkerResponse struct {
+	// Row is the (1-based) row of the job in the batch input, if
+	// known, and JobID the job's ID.
+	Row   int    `csv:"row" json:"row,omitempty"`
+	JobID string `csv:"id" json:"id,omitempty"`
+
 	*TopupResponse
 	ErrorMessage string `csv:"errrorMessage" json:"errorMessage,omitempty"`
 	ErrorCode    string `csv:"errorCode" json:"errorCode,omitempty"`
+
+	// Err is the error the job failed with, for errors.Is/As.
+	Err error `csv:"-" json:"-"`
 }
 
 func (r *TopupWorkerResponse) SetError(err error) *TopupWorkerResponse {
+	r.Err = err
 	r.ErrorMessage = err.Error()
+	r.ErrorCode = errorCodeOf(err)
+	return r
+}
 
-	if e, ok := err.(APIError); ok {
-		r.ErrorCode = e.ErrorCode
-	} else if e, ok := err.(ReloadlyError); ok {
-		r.ErrorCode = e.ErrorCode
-	}
+// TopupWorkerQuote is the quote of a job, see TopupsService.Quote.
+type TopupWorkerQuote struct {
+	Row   int    `csv:"row" json:"row,omitempty"`
+	JobID string `csv:"id" json:"id,omitempty"`
+
+	*TopupQuote
+	ErrorMessage string `csv:"errorMessage" json:"errorMessage,omitempty"`
+	ErrorCode    string `csv:"errorCode" json:"errorCode,omitempty"`
 
+	Err error `csv:"-" json:"-"`
+}
+
+func (r *TopupWorkerQuote) SetError(err error) *TopupWorkerQuote {
+	r.Err = err
+	r.ErrorMessage = err.Error()
+	r.ErrorCode = errorCodeOf(err)
 	return r
 }
 
@@ -77,19 +107,42 @@ func workErrorResponse(err error, d *TopupJob) *TopupWorkerResponse {
 	tr.RecipientPhone = d.Number
 	tr.CountryCode = d.Country
 	tr.RequestedAmount = d.Amount
+	tr.CustomIdentifier = d.CustomIdentifier
 
-	r := &TopupWorkerResponse{tr, "", ""}
+	r := &TopupWorkerResponse{JobID: d.ID, TopupResponse: tr}
 	r.SetError(err)
 	return r
 }
 
 func (t *TopupWorker) DoJob(d *TopupJob) (*TopupResponse, error) {
-	svc := Service(*t)
+	return t.DoJobContext(context.Background(), d)
+}
+
+func (t *TopupWorker) DoJobContext(ctx context.Context, d *TopupJob) (*TopupResponse, error) {
+	svc := (*Service)(t).share()
+
+	ctx, span := svc.startSpan(ctx, "TopupJob",
+		attribute.String("reloadly.job_id", d.ID),
+		attribute.String("reloadly.country", d.Country))
+	res, err := t.doJob(ctx, d)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t *TopupWorker) doJob(ctx context.Context, d *TopupJob) (*TopupResponse, error) {
+	return t.jobTopups(ctx, d).TopupContext(ctx, d.Number, d.Amount)
+}
+
+// jobTopups returns a TopupsService set up for the job.
+func (t *TopupWorker) jobTopups(ctx context.Context, d *TopupJob) *TopupsService {
+	svc := (*Service)(t).share()
 
 	s := svc.Topups()
 
 	if d.Operator != "" {
-		s = s.FindOperator(d.Country, d.Operator).SuggestedAmount(d.Tolerance).AutoFallback()
+		s = s.FindOperatorContext(ctx, d.Country, d.Operator).SuggestedAmount(d.Tolerance).AutoFallback()
+	} else if d.DetectedOperator != nil {
+		s = s.Operator(d.DetectedOperator).SuggestedAmount(d.Tolerance)
 	} else {
 		s = s.AutoDetect(d.Country).SuggestedAmount(d.Tolerance)
 	}
@@ -98,20 +151,52 @@ func (t *TopupWorker) DoJob(d *TopupJob) (*TopupResponse, error) {
 		s = s.CustomIdentifier(d.CustomIdentifier)
 	}
 
-	return s.Topup(d.Number, d.Amount)
+	return s
 }
 
 func (t *TopupWorker) Do(d *TopupJob) *TopupWorkerResponse {
-	res, err := t.DoJob(d)
+	return t.DoContext(context.Background(), d)
+}
+
+func (t *TopupWorker) DoContext(ctx context.Context, d *TopupJob) *TopupWorkerResponse {
+	start := time.Now()
+	res, err := t.DoJobContext(ctx, d)
+	t.Metrics.observeJob(d.Country, err, time.Since(start))
 
 	if err != nil {
 		return workErrorResponse(err, d)
 	}
 
-	return &TopupWorkerResponse{res, "", ""}
+	if res.CustomIdentifier == "" {
+		res.CustomIdentifier = d.CustomIdentifier
+	}
+
+	return &TopupWorkerResponse{JobID: d.ID, TopupResponse: res}
 }
 
 func (t *TopupWorker) Work(i interface{}) interface{} {
 	d := i.(*TopupJob)
 	return t.Do(d)
 }
+
+func (t *TopupWorker) Quote(d *TopupJob) *TopupWorkerQuote {
+	return t.QuoteContext(context.Background(), d)
+}
+
+// QuoteContext quotes the job with the operator and amount DoContext
+// would use, without sending it.
+func (t *TopupWorker) QuoteContext(ctx context.Context, d *TopupJob) *TopupWorkerQuote {
+	q, err := t.jobTopups(ctx, d).QuoteContext(ctx, d.Number, d.Amount)
+	if err != nil {
+		q := &TopupQuote{
+			RecipientPhone:   d.Number,
+			CountryCode:      d.Country,
+			CustomIdentifier: d.CustomIdentifier,
+			OperatorName:     d.Operator,
+		}
+		r := &TopupWorkerQuote{JobID: d.ID, TopupQuote: q}
+		return r.SetError(err)
+	}
+
+	return &TopupWorkerQuote{JobID: d.ID, TopupQuote: q}
+}
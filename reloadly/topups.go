@@ -1,6 +1,8 @@
 package reloadly
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -8,6 +10,9 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TransactionDate time.Time
@@ -24,13 +29,15 @@ func (t *TransactionDate) UnmarshalJSON(b []byte) error {
 }
 
 func (t *TransactionDate) MarshalJSON() ([]byte, error) {
-	format := "2006-01-02 15:04:05"
-	s := time.Time(*t).Format(format)
-	return []byte(s), nil
+	b, _ := t.MarshalCSV()
+	return json.Marshal(string(b))
 }
 
+// MarshalCSV formats the date in UTC, as Reloadly gives it.
 func (t *TransactionDate) MarshalCSV() ([]byte, error) {
-	return t.MarshalJSON()
+	format := "2006-01-02 15:04:05"
+	s := time.Time(*t).UTC().Format(format)
+	return []byte(s), nil
 }
 
 type TopupResponse struct {
@@ -38,7 +45,7 @@ type TopupResponse struct {
 	OperatorTransactionID       string           `csv:"operatorTransactionId" json:"operatorTransactionId,omitempty"`
 	CustomIdentifier            string           `csv:"customIdentifier" json:"customIdentifier,omitempty"`
 	RecipientPhone              string           `csv:"recipientPhone" json:"recipientPhone,omitempty"`
-	RecipientEmail              string           `csv:"recipientEmail" json:"recipientEmairrrrrrrrrl,omitempty"`
+	RecipientEmail              string           `csv:"recipientEmail" json:"recipientEmail,omitempty"`
 	SenderPhone                 string           `csv:"senderPhone" json:"senderPhone,omitempty"`
 	CountryCode                 string           `csv:"countryCode" json:"countryCode,omitempty"`
 	OperatorID                  int64            `csv:"operatorId" json:"operatorId,omitempty"`
@@ -80,23 +87,24 @@ type TopupsService struct {
 	tolerance        float64
 	error            error
 	customIdentifier string
+	retryPolicy      *RetryPolicy
+	idempotent       bool
 }
 
 func NewTopups() *Service {
 	return &Service{
-		http.DefaultClient,
-		"https://topups.reloadly.com",
-		"https://auth.reloadly.com",
-		nil,
-		"",
-		"",
-		"https://topups-sandbox.reloadly.com",
-		"application/com.reloadly.topups-v1+json",
+		Client:       http.DefaultClient,
+		BaseUrl:      "https://topups.reloadly.com",
+		AuthUrl:      "https://auth.reloadly.com",
+		tokens:       &tokenStore{},
+		limits:       newRateLimits(),
+		sandboxUrl:   "https://topups-sandbox.reloadly.com",
+		acceptHeader: "application/com.reloadly.topups-v1+json",
 	}
 }
 
 func (s *Service) Topups() *TopupsService {
-	return &TopupsService{s, false, false, false, nil, "", 0.0, nil, ""}
+	return &TopupsService{s, false, false, false, nil, "", 0.0, nil, "", nil, false}
 }
 
 func (s *TopupsService) New() *TopupsService {
@@ -115,7 +123,11 @@ func (s *TopupsService) GetSetOperator() *Operator {
 }
 
 func (s *TopupsService) FindOperator(country, name string) *TopupsService {
-	op, err := s.SearchOperator(country, name)
+	return s.FindOperatorContext(context.Background(), country, name)
+}
+
+func (s *TopupsService) FindOperatorContext(ctx context.Context, country, name string) *TopupsService {
+	op, err := s.SearchOperatorContext(ctx, country, name)
 	s.operator = op
 	s.error = err
 	return s
@@ -142,6 +154,30 @@ func (s *TopupsService) CustomIdentifier(identifier string) *TopupsService {
 	return s
 }
 
+// Retry sets the policy used to retry the topup request itself,
+// overriding the Service's RetryPolicy. Only errors which guarantee
+// that nothing was charged are retried.
+func (s *TopupsService) Retry(policy *RetryPolicy) *TopupsService {
+	s.retryPolicy = policy
+	return s
+}
+
+// Idempotent makes the topup happen at most once per custom
+// identifier, which must be set. Before sending it, and before
+// sending it again after a failure that may have been charged (see
+// IsChargeUncertain), the transactions report is searched for a topup
+// with the identifier, which is returned if found. Failures are sent
+// again as allowed by the retry policy, or DefaultRetryPolicy.
+//
+// Reloadly may take a moment to add a topup to the report, so after
+// such a failure it waits at least the policy's ReportDelay before
+// checking it again. A topup sent right before a crash may still not
+// be found.
+func (s *TopupsService) Idempotent() *TopupsService {
+	s.idempotent = true
+	return s
+}
+
 func checkLocalRangeAmount(operator *Operator, amount float64, tolerance float64) (float64, error) {
 	min := operator.LocalMinAmount
 	max := operator.LocalMaxAmount
@@ -244,32 +280,42 @@ func GetSuggestedAmount(operator *Operator, amount float64, tolerance float64) (
 	return amt.Pay, nil
 }
 
-// create retry timeout function
-// retries every Xmin for Y mins
-// for a set of ErrorCodes:
-// PHONE_RECENTLY_RECHARGED
-// TRANSACTION_CANNOT_BE_PROCESSED_AT_THE_MOMENT
-// PROVIDER_INTERNAL_ERROR
-// SERVICE_TO_OPERATOR_TEMPORARILY_UNAVAILABLE
-
+// tryAutoFallback reports whether err may be due to the wrong
+// operator, in which case the topup is retried with auto-detection.
 func tryAutoFallback(err error) bool {
-	if e, ok := err.(APIError); ok {
-		switch e.ErrorCode {
+	if !errors.As(err, new(APIError)) {
+		return false
+	}
+
+	return errors.Is(err, ErrTransactionRefusedByOperator) ||
+		errors.Is(err, ErrInvalidRecipientPhone) ||
+		errors.Is(err, ErrInvalidAmountForOperator)
+}
 
-		case "TRANSACTION_REFUSED_BY_OPERATOR",
-			"INVALID_RECIPIENT_PHONE",
-			"INVALID_AMOUNT_FOR_OPERATOR":
+func (s *TopupsService) Topup(mobile string, requestedAmount float64) (*TopupResponse, error) {
+	return s.TopupContext(context.Background(), mobile, requestedAmount)
+}
 
-			return true
+// TopupContext is like Topup, but every request it makes, including
+// operator auto-detection and auto-fallback, is bound to ctx.
+func (s *TopupsService) TopupContext(ctx context.Context, mobile string, requestedAmount float64) (*TopupResponse, error) {
+	ctx, span := s.startSpan(ctx, "Topup", attribute.Float64("reloadly.requested_amount", requestedAmount))
+	if s.customIdentifier != "" {
+		span.SetAttributes(attribute.String("reloadly.custom_identifier", s.customIdentifier))
+	}
 
-		default:
-			return false
-		}
+	res, err := s.topup(ctx, mobile, requestedAmount)
+	if err == nil && res != nil && res.TransactionID != 0 {
+		span.SetAttributes(attribute.Int64("reloadly.transaction_id", res.TransactionID))
 	}
-	return false
+	endSpan(span, err)
+	return res, err
 }
 
-func (s *TopupsService) Topup(mobile string, requestedAmount float64) (*TopupResponse, error) {
+// prepare resolves the operator and the amount of a topup, and
+// returns the request that would send it.
+func (s *TopupsService) prepare(ctx context.Context, mobile string, requestedAmount float64) (*TopupRequest, error) {
+	span := trace.SpanFromContext(ctx)
 	amount := requestedAmount
 
 	if s.error != nil {
@@ -277,17 +323,22 @@ func (s *TopupsService) Topup(mobile string, requestedAmount float64) (*TopupRes
 	}
 
 	if s.autoDetect {
-		op, err := s.OperatorsAutoDetect(mobile, s.country)
+		op, err := s.OperatorsAutoDetectContext(ctx, mobile, s.country)
+		s.Metrics.observeAutoDetect(s.country, err)
 		if err != nil {
 			return nil, err
 		}
 		s.operator = op
+		s.logger().InfoContext(ctx, "auto-detected operator", "country", s.country, "operator_id", op.OperatorID, "operator", op.Name)
 	}
 
 	if s.operator == nil {
 		return nil, ReloadlyError{"INVALID_CALL", "You must set an operator to call Topup"}
 	}
 
+	span.SetAttributes(operatorAttributes(s.operator)...)
+	span.SetAttributes(attribute.String("reloadly.country", s.operator.Country.IsoName))
+
 	// TODO: this is poor naming given current behavior.
 	// It's confusing the way tolerance is overloaded.
 	// needs some rethinking.
@@ -299,6 +350,8 @@ func (s *TopupsService) Topup(mobile string, requestedAmount float64) (*TopupRes
 		amount = a
 	}
 
+	span.SetAttributes(attribute.Float64("reloadly.amount", amount))
+
 	req := &TopupRequest{
 		RecipientPhone: &RecipientPhone{s.operator.Country.IsoName, mobile},
 		OperatorID:     s.operator.OperatorID,
@@ -308,15 +361,44 @@ func (s *TopupsService) Topup(mobile string, requestedAmount float64) (*TopupRes
 	if s.customIdentifier != "" {
 		req.CustomIdentifier = s.customIdentifier
 	}
+	return req, nil
+}
 
-	resp := new(TopupResponse)
-	_, err := s.Request("POST", "/topups", req, resp)
+func (s *TopupsService) topup(ctx context.Context, mobile string, requestedAmount float64) (*TopupResponse, error) {
+	req, err := s.prepare(ctx, mobile, requestedAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := s.retryPolicy
+	if policy == nil {
+		policy = s.RetryPolicy
+	}
+
+	var resp *TopupResponse
+	if s.idempotent || (s.IdempotentTopups && s.customIdentifier != "") {
+		resp, err = s.postIdempotent(ctx, policy, req)
+	} else {
+		resp = new(TopupResponse)
+		_, err = s.requestWithPolicy(ctx, policy, "POST", "/topups", req, resp)
+	}
+	s.Metrics.observeTopup(s.operator.Country.IsoName, s.operator.Name, resp, err)
 
-	// add retries??
 	if err == nil || s.autoFallback == false || !tryAutoFallback(err) {
 		return resp, err
 	}
 
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
 	// try with auto detect!
-	return s.AutoDetect(s.operator.Country.IsoName).Topup(mobile, requestedAmount)
+	s.Metrics.observeFallback(s.operator.Country.IsoName, s.operator.Name, err)
+	s.logger().InfoContext(ctx, "topup failed, falling back to operator auto-detection", "operator_id", s.operator.OperatorID, "operator", s.operator.Name, "error", err)
+
+	attrs := append(operatorAttributes(s.operator), attribute.String("reloadly.fallback_from_error_code", errorCode(err)))
+	ctx, fallback := s.startSpan(ctx, "TopupFallback", attrs...)
+	res, err := s.AutoDetect(s.operator.Country.IsoName).TopupContext(ctx, mobile, requestedAmount)
+	endSpan(fallback, err)
+	return res, err
 }
@@ -1,7 +1,9 @@
 package reloadly
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -426,3 +428,26 @@ func TestTopupCustomIdentifierAddsIdentifier(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestTopupContextDoesNotFallbackWhenContextCanceled(t *testing.T) {
+	ts, mux := TestServerMux()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("auto detect should not be called after cancel")
+	})
+
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(404)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"errorCode": "INVALID_RECIPIENT_PHONE"}`)
+	})
+
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}}
+	op := Operator{Name: "Foodafone", OperatorID: 1, Country: Country{"IN", "India"}}
+	_, err := svc.Topups().Operator(&op).AutoFallback().TopupContext(ctx, "+123", 100)
+
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
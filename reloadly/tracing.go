@@ -0,0 +1,67 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/vlab-research/go-reloadly/reloadly"
+
+// tracer returns a tracer from the Service's TracerProvider, or
+// from the global one if it has none (a no-op unless configured).
+func (s *Service) tracer() trace.Tracer {
+	tp := s.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startSpan starts a span named "reloadly.<name>" as a child of
+// whatever span is in ctx.
+func (s *Service) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer().Start(ctx, "reloadly."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, and its Reloadly error code, on span and
+// ends it. Phone numbers are hidden in the error, as in the logs.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		msg := logError(err)
+		span.SetAttributes(attribute.String("reloadly.error_code", errorCode(err)))
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+func operatorAttributes(op *Operator) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("reloadly.operator_id", op.OperatorID),
+		attribute.String("reloadly.operator_name", op.Name),
+	}
+}
+
+// startHTTPSpan starts a client span for a single http call, and
+// injects the trace context into its headers with the global
+// propagator, so that the request can be linked to the caller's trace.
+func (s *Service) startHTTPSpan(ctx context.Context, req *http.Request) (context.Context, trace.Span) {
+	endpoint := metricsEndpoint(req.URL.Path)
+	ctx, span := s.tracer().Start(ctx, "reloadly "+req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("http.route", endpoint),
+			attribute.String("server.address", req.URL.Host),
+		))
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return ctx, span
+}
@@ -0,0 +1,114 @@
+package reloadly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func testTracerProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTopupFallbackSpansAreNestedUnderCallerSpan(t *testing.T) {
+	dat, _ := ioutil.ReadFile("test/airtel.json")
+	airtel := string(dat)
+	ts, mux := TestServerMux()
+
+	mux.HandleFunc("/operators/auto-detect/phone/+123/countries/IN", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, airtel)
+	})
+
+	count := 0
+	mux.HandleFunc("/topups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if count == 0 {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, `{"errorCode": "INVALID_RECIPIENT_PHONE"}`)
+		} else {
+			fmt.Fprintf(w, `{"transactionId": 42}`)
+		}
+		count++
+	})
+
+	tp, recorder := testTracerProvider()
+	svc := &Service{BaseUrl: ts.URL, Client: &http.Client{}, TracerProvider: tp}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "caller")
+	op := Operator{Name: "Foodafone", OperatorID: 1, Country: Country{"IN", "India"}}
+	_, err := svc.Topups().Operator(&op).AutoFallback().CustomIdentifier("foo").TopupContext(ctx, "+123", 100)
+	assert.Nil(t, err)
+	parent.End()
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	assert.Equal(t, 2, len(spans["reloadly.Topup"]))
+	assert.Equal(t, 1, len(spans["reloadly.TopupFallback"]))
+	assert.Equal(t, 1, len(spans["reloadly.OperatorsAutoDetect"]))
+	assert.Equal(t, 2, len(spans["reloadly POST /topups"]))
+	assert.Equal(t, 1, len(spans["reloadly GET /operators/auto-detect/phone/{phone}/countries/{country}"]))
+
+	fallback := spans["reloadly.TopupFallback"][0]
+	assert.Equal(t, "INVALID_RECIPIENT_PHONE", spanAttribute(fallback, "reloadly.fallback_from_error_code").AsString())
+	assert.Equal(t, int64(1), spanAttribute(fallback, "reloadly.operator_id").AsInt64())
+
+	// the outer topup span is the last to end
+	outer := spans["reloadly.Topup"][1]
+	assert.Equal(t, parent.SpanContext().SpanID(), outer.Parent().SpanID())
+	assert.Equal(t, "foo", spanAttribute(outer, "reloadly.custom_identifier").AsString())
+	assert.Equal(t, "IN", spanAttribute(outer, "reloadly.country").AsString())
+
+	inner := spans["reloadly.Topup"][0]
+	assert.Equal(t, fallback.SpanContext().SpanID(), inner.Parent().SpanID())
+	assert.Equal(t, int64(200), spanAttribute(inner, "reloadly.operator_id").AsInt64())
+	assert.Equal(t, int64(42), spanAttribute(inner, "reloadly.transaction_id").AsInt64())
+
+	failed := spans["reloadly POST /topups"][0]
+	assert.Equal(t, int64(404), spanAttribute(failed, "http.response.status_code").AsInt64())
+}
+
+func TestSpanErrorsHidePhoneNumbers(t *testing.T) {
+	tp, recorder := testTracerProvider()
+	svc := &Service{TracerProvider: tp}
+
+	_, span := svc.startSpan(context.Background(), "test")
+	endSpan(span, TransportError{Err: &url.Error{
+		Op:  "Get",
+		URL: "https://topups.reloadly.com/operators/auto-detect/phone/+447951731337/countries/GB",
+		Err: errors.New("connection refused"),
+	}})
+
+	spans := recorder.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.Contains(t, spans[0].Status().Description, "phone/REDACTED/countries/GB")
+	assert.NotContains(t, spans[0].Status().Description, "447951731337")
+	for _, event := range spans[0].Events() {
+		for _, kv := range event.Attributes {
+			assert.NotContains(t, kv.Value.Emit(), "447951731337")
+		}
+	}
+}